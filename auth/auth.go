@@ -0,0 +1,336 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type (
+	// Perm is an access level. Higher levels include lower ones.
+	Perm int
+
+	// Auth authenticates requests by static tokens, basic auth or
+	// client certificates and authorizes them by path prefix rules.
+	Auth struct {
+		Rules []Rule
+
+		users  map[string][]byte   // name -> bcrypt hash
+		tokens map[[32]byte]string // token sha256 -> name
+
+		dummy []byte // hash to compare passwords of unknown users with, as slow as the real ones
+
+		// bcrypt is slow by design and apt makes many requests,
+		// so good passwords are remembered for a while.
+		// They are keyed by hmac with a per process random key,
+		// so the cache is not a fast hash to brute force.
+		key      [32]byte
+		mu       sync.Mutex
+		verified map[string]verified // name -> last good password
+	}
+
+	verified struct {
+		mac     [sha256.Size]byte
+		expires time.Time
+	}
+
+	// Rule grants Perm to Principal for Prefix path and paths under it.
+	// Prefix matches whole path segments: /a/b covers /a/b and /a/b/c, but not /a/bc.
+	// Principal is a user, token or client certificate common name.
+	// "*" matches everybody including anonymous clients.
+	Rule struct {
+		Principal string
+		Prefix    string
+		Perm      Perm
+	}
+)
+
+// Permissions.
+const (
+	None Perm = iota
+	Read
+	Write
+	Admin
+)
+
+// Anyone is a Rule Principal matching all the clients.
+const Anyone = "*"
+
+// verifiedTTL is how long a good password is accepted without bcrypt check.
+const verifiedTTL = 5 * time.Minute
+
+// ErrBadCredentials is returned when provided credentials are not valid.
+var ErrBadCredentials = errors.New("bad credentials")
+
+var permNames = map[string]Perm{
+	"read":  Read,
+	"write": Write,
+	"admin": Admin,
+}
+
+func New() *Auth {
+	a := &Auth{
+		users:    map[string][]byte{},
+		tokens:   map[[32]byte]string{},
+		verified: map[string]verified{},
+	}
+
+	_, err := rand.Read(a.key[:])
+	if err != nil {
+		panic(err)
+	}
+
+	return a
+}
+
+// ParseRules parses space separated principal:prefix:perm rules.
+//
+//	*:/v0/deb/pool/:read ci:/v0/deb/:write alice:/:admin
+func ParseRules(s string) (rules []Rule, err error) {
+	for _, rs := range strings.Fields(s) {
+		p := strings.Split(rs, ":")
+		if len(p) != 3 || p[0] == "" || !strings.HasPrefix(p[1], "/") {
+			return nil, errors.Errorf("bad rule %q: expected principal:/prefix:perm", rs)
+		}
+
+		perm, ok := permNames[p[2]]
+		if !ok {
+			return nil, errors.Errorf("bad rule %q: unknown permission %q", rs, p[2])
+		}
+
+		rules = append(rules, Rule{Principal: p[0], Prefix: p[1], Perm: perm})
+	}
+
+	return rules, nil
+}
+
+// LoadHtpasswd reads users from htpasswd file with bcrypt hashes.
+func (a *Auth) LoadHtpasswd(fn string) (err error) {
+	return loadFile(fn, a.ReadHtpasswd)
+}
+
+// ReadHtpasswd reads name:bcrypt-hash lines as produced by htpasswd -B.
+func (a *Auth) ReadHtpasswd(r io.Reader) error {
+	maxCost := 0
+
+	err := readLines(r, func(name, hash string) error {
+		cost, err := bcrypt.Cost([]byte(hash))
+		if err != nil {
+			return errors.Wrapf(err, "user %v: not a bcrypt hash", name)
+		}
+
+		if cost > maxCost {
+			maxCost = cost
+		}
+
+		a.users[name] = []byte(hash)
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if a.dummy != nil {
+		if cost, _ := bcrypt.Cost(a.dummy); cost >= maxCost {
+			return nil
+		}
+	}
+
+	var pass [16]byte
+
+	_, err = rand.Read(pass[:])
+	if err != nil {
+		return errors.Wrap(err, "random")
+	}
+
+	a.dummy, err = bcrypt.GenerateFromPassword(pass[:], maxCost)
+	if err != nil {
+		return errors.Wrap(err, "dummy hash")
+	}
+
+	return nil
+}
+
+// LoadTokens reads static tokens file.
+func (a *Auth) LoadTokens(fn string) error {
+	return loadFile(fn, a.ReadTokens)
+}
+
+// ReadTokens reads name:token lines.
+func (a *Auth) ReadTokens(r io.Reader) error {
+	return readLines(r, func(name, token string) error {
+		a.tokens[sha256.Sum256([]byte(token))] = name
+
+		return nil
+	})
+}
+
+// Enabled reports whether any credentials or rules are configured.
+func (a *Auth) Enabled() bool {
+	return a != nil && (len(a.Rules) != 0 || len(a.users) != 0 || len(a.tokens) != 0)
+}
+
+// Authenticate returns request principal.
+// Empty principal with no error means anonymous request.
+// Bearer token is checked first, then basic auth, then verified client certificate.
+func (a *Auth) Authenticate(req *http.Request) (string, error) {
+	if h := req.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		name, ok := a.tokens[sha256.Sum256([]byte(strings.TrimPrefix(h, "Bearer ")))]
+		if !ok {
+			return "", ErrBadCredentials
+		}
+
+		return name, nil
+	}
+
+	if user, pass, ok := req.BasicAuth(); ok {
+		if !a.checkPassword(user, pass) {
+			return "", ErrBadCredentials
+		}
+
+		return user, nil
+	}
+
+	if req.TLS != nil && len(req.TLS.VerifiedChains) != 0 && len(req.TLS.VerifiedChains[0]) != 0 {
+		if cn := req.TLS.VerifiedChains[0][0].Subject.CommonName; cn != "" {
+			return cn, nil
+		}
+	}
+
+	return "", nil
+}
+
+// Allowed reports whether principal has at least need permission for path.
+// Permission is the highest one granted by the matching rules.
+// Everything is allowed if auth is not enabled.
+func (a *Auth) Allowed(principal, path string, need Perm) bool {
+	if !a.Enabled() {
+		return true
+	}
+
+	var perm Perm
+
+	for _, r := range a.Rules {
+		if !underPath(path, r.Prefix) {
+			continue
+		}
+
+		if r.Principal != Anyone && (principal == "" || r.Principal != principal) {
+			continue
+		}
+
+		if r.Perm > perm {
+			perm = r.Perm
+		}
+	}
+
+	return perm >= need
+}
+
+// underPath reports whether path is prefix or is under it.
+func underPath(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+func (a *Auth) checkPassword(user, pass string) bool {
+	hash, ok := a.users[user]
+	if !ok {
+		// take the same time as for a known user, so users can't be enumerated
+		if a.dummy != nil {
+			_ = bcrypt.CompareHashAndPassword(a.dummy, []byte(pass))
+		}
+
+		return false
+	}
+
+	m := hmac.New(sha256.New, a.key[:])
+	_, _ = m.Write([]byte(pass))
+
+	var mac [sha256.Size]byte
+	copy(mac[:], m.Sum(nil))
+
+	now := time.Now()
+
+	a.mu.Lock()
+	last, ok := a.verified[user]
+	a.mu.Unlock()
+
+	if ok && now.Before(last.expires) && hmac.Equal(last.mac[:], mac[:]) {
+		return true
+	}
+
+	if bcrypt.CompareHashAndPassword(hash, []byte(pass)) != nil {
+		return false
+	}
+
+	a.mu.Lock()
+	a.verified[user] = verified{mac: mac, expires: now.Add(verifiedTTL)}
+	a.mu.Unlock()
+
+	return true
+}
+
+func (p Perm) String() string {
+	for n, v := range permNames {
+		if v == p {
+			return n
+		}
+	}
+
+	return "none"
+}
+
+func loadFile(fn string, read func(io.Reader) error) (err error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return errors.Wrap(err, "open")
+	}
+	defer func() {
+		e := f.Close()
+		if err == nil {
+			err = errors.Wrap(e, "close")
+		}
+	}()
+
+	return read(f)
+}
+
+// readLines reads name:value lines skipping empty ones and # comments.
+func readLines(r io.Reader, f func(name, val string) error) error {
+	s := bufio.NewScanner(r)
+
+	for n := 1; s.Scan(); n++ {
+		l := strings.TrimSpace(s.Text())
+
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+
+		i := strings.IndexByte(l, ':')
+		if i <= 0 || i == len(l)-1 {
+			return errors.Errorf("line %d: expected name:value", n)
+		}
+
+		err := f(l[:i], l[i+1:])
+		if err != nil {
+			return errors.Wrapf(err, "line %d", n)
+		}
+	}
+
+	return errors.Wrap(s.Err(), "read")
+}
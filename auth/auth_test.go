@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAuthenticate(t *testing.T) {
+	a := New()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	err = a.ReadHtpasswd(strings.NewReader("# users\nalice:" + string(hash) + "\n"))
+	require.NoError(t, err)
+
+	err = a.ReadTokens(strings.NewReader("ci:tok123\n"))
+	require.NoError(t, err)
+
+	err = a.ReadHtpasswd(strings.NewReader("bob:plain\n"))
+	assert.Error(t, err)
+
+	req := httptest.NewRequest("GET", "/", nil)
+
+	p, err := a.Authenticate(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "", p)
+
+	for i := 0; i < 2; i++ { // second time from verified cache
+		req.SetBasicAuth("alice", "secret")
+
+		p, err = a.Authenticate(req)
+		assert.NoError(t, err)
+		assert.Equal(t, "alice", p)
+	}
+
+	req.SetBasicAuth("alice", "wrong")
+
+	_, err = a.Authenticate(req)
+	assert.Equal(t, ErrBadCredentials, err)
+
+	req.SetBasicAuth("mallory", "secret")
+
+	_, err = a.Authenticate(req)
+	assert.Equal(t, ErrBadCredentials, err)
+
+	// unknown users are checked against the hash of the same cost
+	if cost, err := bcrypt.Cost(a.dummy); assert.NoError(t, err) {
+		assert.Equal(t, bcrypt.MinCost, cost)
+	}
+
+	// the cache is not a plain hash of the password
+	sum := sha256.Sum256([]byte("secret"))
+	assert.NotEqual(t, sum, a.verified["alice"].mac)
+
+	// expired entry is checked with bcrypt again
+	hash, err = bcrypt.GenerateFromPassword([]byte("changed"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	a.users["alice"] = hash
+
+	v := a.verified["alice"]
+	v.expires = time.Now().Add(-time.Second)
+	a.verified["alice"] = v
+
+	req.SetBasicAuth("alice", "secret")
+
+	_, err = a.Authenticate(req)
+	assert.Equal(t, ErrBadCredentials, err)
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer tok123")
+
+	p, err = a.Authenticate(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "ci", p)
+
+	req.Header.Set("Authorization", "Bearer nope")
+
+	_, err = a.Authenticate(req)
+	assert.Equal(t, ErrBadCredentials, err)
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.TLS = &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "builder"}}}},
+	}
+
+	p, err = a.Authenticate(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "builder", p)
+}
+
+func TestAllowed(t *testing.T) {
+	a := New()
+
+	assert.True(t, a.Allowed("", "/v0/deb/pool/x", Admin), "disabled")
+
+	var err error
+
+	a.Rules, err = ParseRules("*:/v0/deb/dists/:read *:/v0/deb/pool/:read ci:/v0/deb/:write alice:/:admin")
+	require.NoError(t, err)
+
+	assert.True(t, a.Allowed("", "/v0/deb/pool/x", Read))
+	assert.False(t, a.Allowed("", "/v0/deb/pool/x", Write))
+	assert.False(t, a.Allowed("", "/v0/deb/packages", Read))
+
+	assert.True(t, a.Allowed("ci", "/v0/deb/pool/x", Write))
+	assert.True(t, a.Allowed("ci", "/v0/deb/packages", Read))
+	assert.False(t, a.Allowed("ci", "/v0/deb/promote", Admin))
+
+	assert.True(t, a.Allowed("alice", "/v0/deb/promote", Admin))
+
+	// prefixes match path segments only
+	a.Rules, err = ParseRules("ci:/api/upload:write")
+	require.NoError(t, err)
+
+	assert.True(t, a.Allowed("ci", "/api/upload", Write))
+	assert.True(t, a.Allowed("ci", "/api/upload/x.deb", Write))
+	assert.False(t, a.Allowed("ci", "/api/upload-admin", Write))
+	assert.False(t, a.Allowed("ci", "/api/uploads/x.deb", Write))
+
+	for _, s := range []string{"ci:/v0:write:x", "ci:v0:write", "ci:/v0:root", ":/:read"} {
+		_, err = ParseRules(s)
+		assert.Error(t, err, s)
+	}
+}
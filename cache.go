@@ -0,0 +1,143 @@
+package limbo
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	// cacheEntry is a parsed pool file.
+	// It's valid while file size and modification time are the same.
+	cacheEntry struct {
+		Size    int64
+		ModTime time.Time
+
+		Package *Package
+	}
+
+	poolCache map[string]*cacheEntry // Filename -> entry
+)
+
+const cacheVersion = 4
+
+func (l *Limbo) cacheFile() string {
+	return filepath.Join(l.DB, "pool_cache.json")
+}
+
+func (l *Limbo) loadCache() (c poolCache, err error) {
+	data, err := ioutil.ReadFile(l.cacheFile())
+	if os.IsNotExist(err) {
+		return poolCache{}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read")
+	}
+
+	var f struct {
+		Version int
+		Files   poolCache
+	}
+
+	err = json.Unmarshal(data, &f)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode")
+	}
+
+	if f.Version != cacheVersion || f.Files == nil {
+		l.tr.Printw("pool cache dropped", "version", f.Version, "expected", cacheVersion)
+
+		return poolCache{}, nil
+	}
+
+	return f.Files, nil
+}
+
+func (l *Limbo) saveCache(c poolCache) (err error) {
+	if l.closed {
+		return ErrClosed
+	}
+
+	data, err := json.Marshal(struct {
+		Version int
+		Files   poolCache
+	}{
+		Version: cacheVersion,
+		Files:   c,
+	})
+	if err != nil {
+		return errors.Wrap(err, "encode")
+	}
+
+	return writeFileAtomic(l.cacheFile(), data, 0644)
+}
+
+// lookup returns cached package if the file is not changed since it was cached.
+func (c poolCache) lookup(name string, inf os.FileInfo) *Package {
+	e, ok := c[name]
+	if !ok || e.Size != inf.Size() || !e.ModTime.Equal(inf.ModTime()) {
+		return nil
+	}
+
+	return e.Package
+}
+
+func (c poolCache) add(p *Package, inf os.FileInfo) {
+	c[p.Filename] = &cacheEntry{
+		Size:    inf.Size(),
+		ModTime: inf.ModTime(),
+		Package: p,
+	}
+}
+
+// writeFileAtomic writes data to a temp file and renames it to fn.
+func writeFileAtomic(fn string, data []byte, perm os.FileMode) (err error) {
+	dir := filepath.Dir(fn)
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return errors.Wrap(err, "create dir")
+	}
+
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(fn)+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "create temp file")
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	_, err = f.Write(data)
+	if err != nil {
+		return errors.Wrap(err, "write")
+	}
+
+	err = f.Chmod(perm)
+	if err != nil {
+		return errors.Wrap(err, "chmod")
+	}
+
+	err = f.Sync()
+	if err != nil {
+		return errors.Wrap(err, "sync")
+	}
+
+	err = f.Close()
+	if err != nil {
+		return errors.Wrap(err, "close")
+	}
+
+	err = os.Rename(f.Name(), fn)
+	if err != nil {
+		return errors.Wrap(err, "rename")
+	}
+
+	return nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/nikandfor/tlog"
+	"github.com/pkg/errors"
+)
+
+type (
+	server struct {
+		*http.Server
+		l   net.Listener
+		tls bool
+	}
+)
+
+// listenFDsStart is the first inherited listener fd, as in systemd socket activation.
+const listenFDsStart = 3
+
+// readyFDEnv names the fd a restarted process reports readiness to its parent by.
+const readyFDEnv = "LIMBO_READY_FD"
+
+// listen returns i-th listener inherited from the parent process or a new one.
+// Listeners are passed the systemd way: LISTEN_FDS is the number of fds starting from 3.
+// LISTEN_PID is checked if set.
+func listen(i int, addr string) (net.Listener, error) {
+	if pid := os.Getenv("LISTEN_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return net.Listen("tcp", addr)
+	}
+
+	n, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if i >= n {
+		return net.Listen("tcp", addr)
+	}
+
+	f := os.NewFile(uintptr(listenFDsStart+i), "listener"+strconv.Itoa(i))
+
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "inherited listener %d", i)
+	}
+
+	_ = f.Close()
+
+	tlog.Printw("inherited listener", "fd", listenFDsStart+i, "addr", l.Addr())
+
+	return l, nil
+}
+
+// serve runs servers until SIGTERM or SIGINT and then shuts them down
+// letting active requests finish for timeout.
+// On SIGUSR2 a new process is started with the listeners passed to it.
+// Once it's ready this one is shut down the same way.
+// If it isn't ready in restartTimeout it's killed and this one keeps serving.
+func serve(srvs []*server, timeout, restartTimeout time.Duration) error {
+	errc := make(chan error, len(srvs))
+
+	for _, s := range srvs {
+		go func(s *server) {
+			var err error
+			if s.tls {
+				err = s.ServeTLS(s.l, "", "")
+			} else {
+				err = s.Serve(s.l)
+			}
+
+			if err == http.ErrServerClosed {
+				return
+			}
+
+			errc <- err
+		}(s)
+	}
+
+	err := notifyReady()
+	if err != nil {
+		tlog.Printw("notify parent", "err", err)
+	}
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGTERM, syscall.SIGINT, syscall.SIGUSR2)
+	defer signal.Stop(sigc)
+
+loop:
+	for {
+		select {
+		case err := <-errc:
+			tlog.Printw("serve", "err", err)
+
+			shutdown(srvs, timeout)
+
+			return err
+		case sig := <-sigc:
+			if sig != syscall.SIGUSR2 {
+				tlog.Printw("shutting down", "signal", sig, "timeout", timeout)
+				break loop
+			}
+
+			pid, err := restart(srvs, restartTimeout)
+			if err != nil {
+				tlog.Printw("restart", "err", err)
+				continue
+			}
+
+			tlog.Printw("restarted, shutting down", "new_pid", pid, "timeout", timeout)
+
+			break loop
+		}
+	}
+
+	shutdown(srvs, timeout)
+
+	return nil
+}
+
+func shutdown(srvs []*server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for _, s := range srvs {
+		err := s.Shutdown(ctx)
+		if err == nil {
+			continue
+		}
+
+		tlog.Printw("shutdown", "addr", s.l.Addr(), "err", err)
+
+		_ = s.Close()
+	}
+}
+
+// restart starts the same command with the listeners passed to it
+// and waits for it to get ready.
+func restart(srvs []*server, timeout time.Duration) (pid int, err error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, errors.Wrap(err, "get executable")
+	}
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	for _, s := range srvs {
+		fl, ok := s.l.(interface{ File() (*os.File, error) })
+		if !ok {
+			return 0, errors.Errorf("listener %v can't be passed", s.l.Addr())
+		}
+
+		f, err := fl.File()
+		if err != nil {
+			return 0, errors.Wrap(err, "listener file")
+		}
+
+		defer f.Close()
+
+		cmd.ExtraFiles = append(cmd.ExtraFiles, f)
+	}
+
+	rp, wp, err := os.Pipe()
+	if err != nil {
+		return 0, errors.Wrap(err, "ready pipe")
+	}
+
+	defer rp.Close()
+	defer wp.Close()
+
+	cmd.ExtraFiles = append(cmd.ExtraFiles, wp)
+
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, "LISTEN_") && !strings.HasPrefix(e, readyFDEnv+"=") {
+			cmd.Env = append(cmd.Env, e)
+		}
+	}
+
+	cmd.Env = append(cmd.Env,
+		"LISTEN_FDS="+strconv.Itoa(len(srvs)),
+		readyFDEnv+"="+strconv.Itoa(listenFDsStart+len(srvs)),
+	)
+
+	err = cmd.Start()
+	if err != nil {
+		return 0, errors.Wrap(err, "start")
+	}
+
+	// only the child keeps the write end, so read fails if it exits
+	_ = wp.Close()
+
+	tlog.Printw("started new process, wait for it to get ready", "pid", cmd.Process.Pid, "timeout", timeout)
+
+	err = rp.SetReadDeadline(time.Now().Add(timeout))
+	if err == nil {
+		_, err = rp.Read(make([]byte, 1))
+	}
+
+	if err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+
+		return 0, errors.Wrap(err, "wait for new process")
+	}
+
+	return cmd.Process.Pid, nil
+}
+
+// restarted reports whether the process is started by restart.
+func restarted() bool {
+	return os.Getenv(readyFDEnv) != ""
+}
+
+// notifyReady tells the parent the process is serving if it's restarted.
+func notifyReady() error {
+	v := os.Getenv(readyFDEnv)
+	if v == "" {
+		return nil
+	}
+
+	_ = os.Unsetenv(readyFDEnv)
+
+	fd, err := strconv.Atoi(v)
+	if err != nil {
+		return errors.Wrapf(err, "parse %v", readyFDEnv)
+	}
+
+	f := os.NewFile(uintptr(fd), "ready")
+	defer f.Close()
+
+	_, err = f.Write([]byte{'\n'})
+
+	return err
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nikandfor/tlog"
+	"github.com/pkg/errors"
+
+	"github.com/rndcenter/limbo"
+	"github.com/rndcenter/limbo/auth"
+)
+
+type (
+	errorResponse struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}
+
+	snapshotRequest struct {
+		Name  string `json:"name"`
+		Suite string `json:"suite"`
+	}
+
+	promoteRequest struct {
+		Package string `json:"package"`
+		Version string `json:"version"`
+		From    string `json:"from"`
+		To      string `json:"to"`
+	}
+
+	// externalCache keeps external Packages indexes loaded for ttl.
+	externalCache struct {
+		locs []string
+		ttl  time.Duration
+
+		mu     sync.Mutex
+		pkgs   []*limbo.Package
+		loaded time.Time
+	}
+)
+
+// authorize rejects requests without need permission for the path.
+func authorize(a *auth.Auth, need auth.Perm) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !a.Enabled() {
+			return
+		}
+
+		who, err := a.Authenticate(c.Request)
+		if err != nil {
+			c.Header("WWW-Authenticate", `Basic realm="limbo"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, errorResponse{Error: "unauthorized", Message: err.Error()})
+			return
+		}
+
+		if a.Allowed(who, c.Request.URL.Path, need) {
+			c.Set("principal", who)
+			return
+		}
+
+		if who == "" {
+			c.Header("WWW-Authenticate", `Basic realm="limbo"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, errorResponse{Error: "unauthorized", Message: "authentication required"})
+			return
+		}
+
+		tlog.Printw("access denied", "principal", who, "path", c.Request.URL.Path, "need", need)
+
+		c.AbortWithStatusJSON(http.StatusForbidden, errorResponse{Error: "forbidden", Message: need.String() + " permission required"})
+	}
+}
+
+func uploadPut(lim *limbo.Limbo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p, err := lim.UploadTo(c.Request.Body, c.Query("suite"), c.Query("component"))
+		if err != nil {
+			writeError(c, errors.Wrap(err, "upload"))
+			return
+		}
+
+		c.JSON(http.StatusCreated, p)
+	}
+}
+
+func uploadMultipart(lim *limbo.Limbo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		mr, err := c.Request.MultipartReader()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, errorResponse{Error: "bad_request", Message: err.Error()})
+			return
+		}
+
+		res := []*limbo.Package{}
+
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				c.JSON(http.StatusBadRequest, errorResponse{Error: "bad_request", Message: err.Error()})
+				return
+			}
+
+			if part.FileName() == "" {
+				continue
+			}
+
+			p, err := lim.UploadTo(part, c.Query("suite"), c.Query("component"))
+			if err != nil {
+				writeError(c, errors.Wrapf(err, "upload %v", part.FileName()))
+				return
+			}
+
+			res = append(res, p)
+		}
+
+		c.JSON(http.StatusCreated, res)
+	}
+}
+
+func promoteHandler(lim *limbo.Limbo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req promoteRequest
+
+		err := c.ShouldBindJSON(&req)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, errorResponse{Error: "bad_request", Message: err.Error()})
+			return
+		}
+
+		moved, err := lim.Promote(req.Package, req.Version, req.From, req.To)
+		if err != nil {
+			writeError(c, errors.Wrap(err, "promote"))
+			return
+		}
+
+		c.JSON(http.StatusOK, moved)
+	}
+}
+
+func snapshotCreateHandler(lim *limbo.Limbo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req snapshotRequest
+
+		err := c.ShouldBindJSON(&req)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, errorResponse{Error: "bad_request", Message: err.Error()})
+			return
+		}
+
+		s, err := lim.CreateSnapshot(req.Suite, req.Name)
+		if err != nil {
+			writeError(c, errors.Wrap(err, "create snapshot"))
+			return
+		}
+
+		c.JSON(http.StatusCreated, s)
+	}
+}
+
+// snapshotFiles serves snapshots/<name>/dists from the snapshot dir
+// and snapshots/<name>/pool from the shared pool, so a snapshot is a complete apt repo.
+// Snapshots list is returned for the root.
+func snapshotFiles(lim *limbo.Limbo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p := path.Clean(c.Param("filepath"))
+
+		if p == "/" {
+			ss, err := lim.ListSnapshots()
+			if err != nil {
+				writeError(c, errors.Wrap(err, "list snapshots"))
+				return
+			}
+
+			c.JSON(http.StatusOK, ss)
+
+			return
+		}
+
+		name, rest := p[1:], ""
+		if i := strings.IndexByte(name, '/'); i != -1 {
+			name, rest = name[:i], name[i+1:]
+		}
+
+		root := filepath.Join(lim.Snapshots, name)
+
+		if _, err := os.Stat(root); err != nil || strings.HasPrefix(name, ".") {
+			c.Status(http.StatusNotFound)
+			return
+		}
+
+		fn := filepath.Join(root, filepath.FromSlash(rest))
+
+		if rest == "pool" || strings.HasPrefix(rest, "pool/") {
+			fn = filepath.Join(lim.Path, filepath.FromSlash(rest))
+		}
+
+		http.ServeFile(c.Writer, c.Request, fn)
+	}
+}
+
+func proxyHandler(proxies map[string]*limbo.Proxy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p, ok := proxies[c.Param("name")]
+		if !ok {
+			c.JSON(http.StatusNotFound, errorResponse{Error: limbo.ReasonNotFound, Message: "no such proxy"})
+			return
+		}
+
+		req := c.Request.Clone(c.Request.Context())
+		req.URL.Path = c.Param("filepath")
+
+		p.ServeHTTP(c.Writer, req)
+	}
+}
+
+func searchHandler(lim *limbo.Limbo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		res, err := lim.Search(searchQuery(c))
+		if err != nil {
+			writeError(c, errors.Wrap(err, "search"))
+			return
+		}
+
+		if res == nil {
+			res = []*limbo.PackageRef{}
+		}
+
+		c.JSON(http.StatusOK, res)
+	}
+}
+
+func packageHandler(lim *limbo.Limbo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q := searchQuery(c)
+		q.Name = c.Param("name")
+
+		res, err := lim.Search(q)
+		if err != nil {
+			writeError(c, errors.Wrap(err, "search"))
+			return
+		}
+
+		if len(res) == 0 {
+			c.JSON(http.StatusNotFound, errorResponse{Error: limbo.ReasonNotFound, Message: "package not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, res)
+	}
+}
+
+func removeHandler(lim *limbo.Limbo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		removed, err := lim.Remove(c.Param("name"), c.Param("version"), c.Param("arch"), c.Query("suite"), c.Query("purge") == "true")
+		if err != nil {
+			writeError(c, errors.Wrap(err, "remove"))
+			return
+		}
+
+		c.JSON(http.StatusOK, removed)
+	}
+}
+
+func searchQuery(c *gin.Context) limbo.Query {
+	return limbo.Query{
+		Name:      c.Query("name"),
+		Version:   c.Query("version"),
+		Arch:      c.Query("arch"),
+		Suite:     c.Query("suite"),
+		Component: c.Query("component"),
+	}
+}
+
+func checkDepsHandler(lim *limbo.Limbo, external *externalCache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ext := external.get()
+
+		reps, err := lim.CheckDeps(ext)
+		if err != nil {
+			writeError(c, errors.Wrap(err, "check deps"))
+			return
+		}
+
+		c.JSON(http.StatusOK, reps)
+	}
+}
+
+// newExternalCache loads indexes, so bad locations are reported at startup.
+func newExternalCache(locs []string, ttl time.Duration) (*externalCache, error) {
+	ext, err := loadExternal(locs)
+	if err != nil {
+		return nil, err
+	}
+
+	return &externalCache{
+		locs:   locs,
+		ttl:    ttl,
+		pkgs:   ext,
+		loaded: time.Now(),
+	}, nil
+}
+
+// get returns cached packages reloading them if ttl expired.
+// Stale packages are returned if reload fails.
+func (e *externalCache) get() []*limbo.Package {
+	defer e.mu.Unlock()
+	e.mu.Lock()
+
+	if len(e.locs) == 0 || e.ttl <= 0 || time.Since(e.loaded) < e.ttl {
+		return e.pkgs
+	}
+
+	ext, err := loadExternal(e.locs)
+	if err != nil {
+		tlog.Printw("reload external indexes", "err", err)
+
+		// don't retry on every request
+		e.loaded = time.Now()
+
+		return e.pkgs
+	}
+
+	e.pkgs = ext
+	e.loaded = time.Now()
+
+	return e.pkgs
+}
+
+func writeError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	resp := errorResponse{
+		Error:   "internal",
+		Message: err.Error(),
+	}
+
+	var pe *limbo.PackageError
+	if errors.As(err, &pe) {
+		resp.Error = pe.Reason
+
+		switch pe.Reason {
+		case limbo.ReasonConflict:
+			status = http.StatusConflict
+		case limbo.ReasonNotFound:
+			status = http.StatusNotFound
+		default:
+			status = http.StatusBadRequest
+		}
+	}
+
+	tlog.Printw("request failed", "path", c.Request.URL.Path, "status", status, "err", err)
+
+	c.JSON(status, resp)
+}
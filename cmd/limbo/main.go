@@ -1,12 +1,18 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/nikandfor/cli"
@@ -14,8 +20,12 @@ import (
 	"github.com/nikandfor/tlog/ext/tlflag"
 	"github.com/nikandfor/tlog/ext/tlgin"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/crypto/openpgp"
 
 	"github.com/rndcenter/limbo"
+	"github.com/rndcenter/limbo/auth"
 	"github.com/rndcenter/limbo/deb"
 )
 
@@ -26,6 +36,16 @@ func main() {
 		EnvPrefix: "LIMBO_",
 		Flags: []*cli.Flag{
 			cli.NewFlag("path,repo", "repo", "repo root path for local storage"),
+			cli.NewFlag("suite", "stable", "default distribution suite name"),
+			cli.NewFlag("component", "main", "default distribution component name"),
+			cli.NewFlag("origin", "limbo", "Release Origin field"),
+			cli.NewFlag("label", "limbo", "Release Label field"),
+			cli.NewFlag("codename", "", "Release Codename field"),
+			cli.NewFlag("description", "", "Release Description field"),
+			cli.NewFlag("valid-for", time.Duration(0), "Release Valid-Until offset from Date"),
+			cli.NewFlag("sign-key", "", "armored OpenPGP private key file to sign Release with"),
+			cli.NewFlag("sign-passphrase", "", "passphrase for the sign key"),
+			cli.NewFlag("retention", "", "retention rules: suite:package:last=N,days=D separated by spaces"),
 			cli.NewFlag("log", "stderr", "log destination"),
 			cli.NewFlag("v", "", "verbosity"),
 			cli.NewFlag("debug", "", "debug address"),
@@ -37,10 +57,91 @@ func main() {
 			Action: run,
 			Flags: []*cli.Flag{
 				cli.NewFlag("listen,l", ":80", "address to listen to"),
+				cli.NewFlag("tls-cert", "", "TLS certificate file, serve https if set"),
+				cli.NewFlag("tls-key", "", "TLS private key file"),
+				cli.NewFlag("tls-client-ca", "", "CA certificates file to verify optional client certificates with"),
+				cli.NewFlag("tls-reload-interval", 10*time.Second, "interval to check certificate files for changes, SIGHUP only if 0"),
+				cli.NewFlag("redirect-http", "", "address to listen plain http on and redirect to https"),
+				cli.NewFlag("shutdown-timeout", 30*time.Second, "time to let active requests finish on SIGTERM"),
+				cli.NewFlag("restart-timeout", time.Minute, "time to wait for the new process to get ready on SIGUSR2"),
+				cli.NewFlag("external", "", "comma separated external Packages indexes assumed present by deps check"),
+				cli.NewFlag("external-ttl", time.Hour, "external Packages indexes reload interval, never if 0"),
+				cli.NewFlag("proxy", "", "upstream repos to cache served at proxy/<name>/: name=url separated by spaces"),
+				cli.NewFlag("proxy-ttl", 5*time.Minute, "upstream Release files refresh interval"),
+				cli.NewFlag("proxy-keyring", "", "armored OpenPGP keyring to verify upstream Release files with"),
+				cli.NewFlag("auth-htpasswd", "", "htpasswd file with bcrypt hashes for basic auth"),
+				cli.NewFlag("auth-tokens", "", "static tokens file: name:token per line"),
+				cli.NewFlag("auth-rules", "", "access rules: principal:/path/prefix:read|write|admin separated by spaces, * is anyone"),
 			},
 		}, {
 			Name:   "reindex",
 			Action: reindex,
+		}, {
+			Name:        "promote",
+			Description: "move package reference between suites: promote <pkg> <version> --from staging --to stable",
+			Action:      promote,
+			Args:        cli.Args{},
+			Flags: []*cli.Flag{
+				cli.NewFlag("from", "", "source suite"),
+				cli.NewFlag("to", "", "destination suite"),
+			},
+		}, {
+			Name:        "rm",
+			Description: "remove package from suite: rm <pkg> <version> <arch>",
+			Action:      rm,
+			Args:        cli.Args{},
+			Flags: []*cli.Flag{
+				cli.NewFlag("purge", false, "remove from all the suites and delete the pool file"),
+			},
+		}, {
+			Name:        "gc",
+			Description: "apply retention rules and delete pool files no suite or snapshot references",
+			Action:      gc,
+			Flags: []*cli.Flag{
+				cli.NewFlag("dry-run,n", false, "only report what would be removed"),
+			},
+		}, {
+			Name: "mirror",
+			Commands: []*cli.Command{{
+				Name:        "sync",
+				Description: "download upstream repo indexes and packages into the pool: mirror sync <url>",
+				Action:      mirrorSync,
+				Args:        cli.Args{},
+				Flags: []*cli.Flag{
+					cli.NewFlag("upstream-suite", "", "upstream suite"),
+					cli.NewFlag("components", "main", "comma separated upstream components"),
+					cli.NewFlag("arch", "", "comma separated architectures"),
+					cli.NewFlag("into", "", "local suite, upstream suite name if empty"),
+					cli.NewFlag("packages", "", "comma separated package name glob patterns, all if empty"),
+					cli.NewFlag("priority", "", "comma separated priorities, all if empty"),
+					cli.NewFlag("prune", false, "drop packages gone from upstream from the local suite"),
+					cli.NewFlag("keyring", "", "armored OpenPGP keyring to verify upstream Release with"),
+				},
+			}},
+		}, {
+			Name: "snapshot",
+			Commands: []*cli.Command{{
+				Name:        "create",
+				Description: "freeze suite into read-only snapshot: snapshot create <name>",
+				Action:      snapshotCreate,
+				Args:        cli.Args{},
+				Flags: []*cli.Flag{
+					cli.NewFlag("from", "", "suite to snapshot, default suite if empty"),
+				},
+			}, {
+				Name:   "list",
+				Action: snapshotList,
+			}},
+		}, {
+			Name: "check",
+			Commands: []*cli.Command{{
+				Name:        "deps",
+				Description: "report unsatisfiable Depends and Pre-Depends",
+				Action:      checkDeps,
+				Flags: []*cli.Flag{
+					cli.NewFlag("external", "", "comma separated external Packages indexes (files or urls) assumed present"),
+				},
+			}},
 		}, {
 			Name:   "deb",
 			Action: debdump,
@@ -49,6 +150,12 @@ func main() {
 				Name:   "repack",
 				Action: debrepack,
 				Args:   cli.Args{},
+				Flags: []*cli.Flag{
+					cli.NewFlag("control-compression", "", "control.tar compression (none, gzip, xz, zstd), keep original if empty"),
+					cli.NewFlag("data-compression", "", "data.tar compression (none, gzip, xz, zstd), keep original if empty"),
+					cli.NewFlag("compression-level", 0, "compression level: 1-9 for gzip and xz, 1-22 for zstd, format default if 0"),
+					cli.NewFlag("reproducible", false, "deterministic output (honors SOURCE_DATE_EPOCH)"),
+				},
 			}},
 		}, {
 			Name:   "q",
@@ -73,13 +180,28 @@ func before(c *cli.Command) error {
 	tlog.SetLabels(ls)
 
 	if a := c.String("debug"); a != "" {
+		http.Handle("/metrics", promhttp.Handler())
+
 		go func() {
-			err := http.ListenAndServe(a, nil)
+			l, err := net.Listen("tcp", a)
+
+			// parent process keeps the address until it's shut down
+			for err != nil && restarted() {
+				tlog.Printw("listen debug server, retry", "addr", a, "err", err)
+				time.Sleep(time.Second)
+
+				l, err = net.Listen("tcp", a)
+			}
+
+			if err == nil {
+				tlog.Printw("listen debug server", "addr", a)
+
+				err = http.Serve(l, nil)
+			}
+
 			tlog.Printw("debug server", "err", err, tlog.KeyLogLevel, tlog.Fatal)
 			os.Exit(1)
 		}()
-
-		tlog.Printw("listen debug server", "addr", a)
 	}
 
 	gin.SetMode(gin.ReleaseMode)
@@ -90,12 +212,7 @@ func before(c *cli.Command) error {
 func run(c *cli.Command) error {
 	tlog.Printf("os.Args: %q", os.Args)
 
-	pth := c.String("path")
-
-	ctx := context.Background()
-	ctx = tlog.ContextWithLogger(ctx, tlog.DefaultLogger)
-
-	lim, err := limbo.New(ctx, pth)
+	lim, err := newLimbo(c)
 	if err != nil {
 		return errors.Wrap(err, "open limbo")
 	}
@@ -105,35 +222,241 @@ func run(c *cli.Command) error {
 		return errors.Wrap(err, "update limbo index")
 	}
 
+	err = prometheus.Register(lim)
+	if err != nil {
+		return errors.Wrap(err, "register metrics")
+	}
+
+	au, err := newAuth(c)
+	if err != nil {
+		return errors.Wrap(err, "init auth")
+	}
+
 	r := gin.New()
 
-	r.Use(tlgin.Tracer)
+	r.Use(tlgin.Tracer, instrument)
 
 	dr := r.Group("/v0/deb/")
 
-	dr.StaticFS("pool", http.Dir(lim.Pool))
+	rd := dr.Group("", authorize(au, auth.Read))
+	wr := dr.Group("", authorize(au, auth.Write))
+	ad := dr.Group("", authorize(au, auth.Admin))
+
+	rd.StaticFS("pool", http.Dir(lim.Pool))
+	rd.StaticFS("dists", http.Dir(lim.Dists))
+
+	wr.PUT("pool/*filepath", uploadPut(lim))
+	wr.POST("upload", uploadMultipart(lim))
+	ad.POST("promote", promoteHandler(lim))
+
+	ad.POST("snapshots", snapshotCreateHandler(lim))
+	rd.GET("snapshots/*filepath", snapshotFiles(lim))
+
+	rd.GET("packages", searchHandler(lim))
+	rd.GET("packages/:name", packageHandler(lim))
+	ad.DELETE("packages/:name/:version/:arch", removeHandler(lim))
+
+	external, err := newExternalCache(splitList(c.String("external")), c.Duration("external-ttl"))
+	if err != nil {
+		return errors.Wrap(err, "load external indexes")
+	}
+
+	rd.GET("check/deps", checkDepsHandler(lim, external))
+
+	proxies, err := newProxies(c, lim)
+	if err != nil {
+		return errors.Wrap(err, "init proxies")
+	}
+
+	if len(proxies) != 0 {
+		rd.GET("proxy/:name/*filepath", proxyHandler(proxies))
+		rd.HEAD("proxy/:name/*filepath", proxyHandler(proxies))
+	}
+
+	if lim.Signer != nil {
+		rd.GET("key.asc", func(c *gin.Context) {
+			c.Header("Content-Type", "application/pgp-keys")
+
+			err := lim.Signer.WritePublicKey(c.Writer)
+			if err != nil {
+				tlog.Printw("write public key", "err", err)
+			}
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	l, err := net.Listen("tcp", c.String("listen"))
+	tlsConf, err := newTLSConfig(ctx, c)
+	if err != nil {
+		return errors.Wrap(err, "init tls")
+	}
+
+	l, err := listen(0, c.String("listen"))
 	if err != nil {
 		return errors.Wrap(err, "listen")
 	}
 
-	tlog.Printw("listening", "addr", l.Addr())
+	tlog.Printw("listening", "addr", l.Addr(), "tls", tlsConf != nil)
+
+	srvs := []*server{{
+		Server: &http.Server{
+			Handler:   r,
+			TLSConfig: tlsConf,
+		},
+		l:   l,
+		tls: tlsConf != nil,
+	}}
+
+	if a := c.String("redirect-http"); a != "" {
+		if tlsConf == nil {
+			return errors.New("--redirect-http requires --tls-cert and --tls-key")
+		}
+
+		rs, err := redirectServer(a, c.String("listen"))
+		if err != nil {
+			return errors.Wrap(err, "redirect http")
+		}
+
+		srvs = append(srvs, rs)
+	}
 
-	err = http.Serve(l, r)
+	err = serve(srvs, c.Duration("shutdown-timeout"), c.Duration("restart-timeout"))
 
-	tlog.Printw("serve", "err", err)
+	// wait for index writes of requests still running after the timeout
+	if e := lim.Close(); err == nil {
+		err = errors.Wrap(e, "close limbo")
+	}
 
 	return err
 }
 
-func reindex(c *cli.Command) error {
-	pth := c.String("path")
+func newAuth(c *cli.Command) (a *auth.Auth, err error) {
+	a = auth.New()
 
-	ctx := context.Background()
-	ctx = tlog.ContextWithLogger(ctx, tlog.DefaultLogger)
+	a.Rules, err = auth.ParseRules(c.String("auth-rules"))
+	if err != nil {
+		return nil, errors.Wrap(err, "parse rules")
+	}
+
+	if f := c.String("auth-htpasswd"); f != "" {
+		err = a.LoadHtpasswd(f)
+		if err != nil {
+			return nil, errors.Wrap(err, "load htpasswd")
+		}
+	}
+
+	if f := c.String("auth-tokens"); f != "" {
+		err = a.LoadTokens(f)
+		if err != nil {
+			return nil, errors.Wrap(err, "load tokens")
+		}
+	}
+
+	if a.Enabled() && len(a.Rules) == 0 {
+		tlog.Printw("auth credentials are configured but no rules, everything is denied")
+	}
+
+	return a, nil
+}
+
+func newProxies(c *cli.Command, lim *limbo.Limbo) (map[string]*limbo.Proxy, error) {
+	keyring, err := loadKeyring(c.String("proxy-keyring"))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := tlog.ContextWithLogger(context.Background(), tlog.DefaultLogger)
+
+	proxies := map[string]*limbo.Proxy{}
+
+	for _, spec := range strings.Fields(c.String("proxy")) {
+		kv := strings.SplitN(spec, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || strings.ContainsAny(kv[0], "/.") {
+			return nil, errors.Errorf("bad proxy spec: %q", spec)
+		}
+
+		p := limbo.NewProxy(ctx, kv[1], filepath.Join(lim.Path, "proxy", kv[0]))
+		p.TTL = c.Duration("proxy-ttl")
+		p.Keyring = keyring
+
+		proxies[kv[0]] = p
+
+		tlog.Printw("proxy", "name", kv[0], "upstream", kv[1])
+	}
+
+	return proxies, nil
+}
+
+func loadKeyring(fn string) (openpgp.EntityList, error) {
+	if fn == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, errors.Wrap(err, "read keyring")
+	}
+
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrap(err, "parse keyring")
+	}
+
+	return keyring, nil
+}
+
+func mirrorSync(c *cli.Command) error {
+	if c.Args.Len() != 1 {
+		return errors.New("expected upstream url")
+	}
+
+	if c.String("upstream-suite") == "" || c.String("arch") == "" {
+		return errors.New("--upstream-suite and --arch are required")
+	}
+
+	lim, err := newLimbo(c)
+	if err != nil {
+		return errors.Wrap(err, "open limbo")
+	}
+
+	keyring, err := loadKeyring(c.String("keyring"))
+	if err != nil {
+		return err
+	}
+
+	err = lim.Load()
+	if err != nil {
+		return errors.Wrap(err, "load pool")
+	}
 
-	lim, err := limbo.New(ctx, pth)
+	rep, err := lim.Mirror(limbo.MirrorOptions{
+		URL:        c.Args.First(),
+		Suite:      c.String("upstream-suite"),
+		Components: splitList(c.String("components")),
+		Archs:      splitList(c.String("arch")),
+		Into:       c.String("into"),
+		Packages:   splitList(c.String("packages")),
+		Priorities: splitList(c.String("priority")),
+		Prune:      c.Bool("prune"),
+		Keyring:    keyring,
+	})
+	if rep != nil {
+		for _, fn := range rep.Failed {
+			fmt.Printf("failed: %s\n", fn)
+		}
+
+		fmt.Printf("%d packages, %d downloaded (%d bytes), %d pruned, %d failed\n", rep.Packages, rep.Downloaded, rep.Size, rep.Pruned, len(rep.Failed))
+	}
+	if err != nil {
+		return errors.Wrap(err, "mirror")
+	}
+
+	return nil
+}
+
+func reindex(c *cli.Command) error {
+	lim, err := newLimbo(c)
 	if err != nil {
 		return errors.Wrap(err, "open limbo")
 	}
@@ -146,6 +469,231 @@ func reindex(c *cli.Command) error {
 	return nil
 }
 
+func promote(c *cli.Command) error {
+	if c.Args.Len() != 2 {
+		return errors.New("expected package name and version")
+	}
+
+	lim, err := newLimbo(c)
+	if err != nil {
+		return errors.Wrap(err, "open limbo")
+	}
+
+	err = lim.Load()
+	if err != nil {
+		return errors.Wrap(err, "load pool")
+	}
+
+	moved, err := lim.Promote(c.Args[0], c.Args[1], c.String("from"), c.String("to"))
+	if err != nil {
+		return errors.Wrap(err, "promote")
+	}
+
+	for _, p := range moved {
+		fmt.Printf("%s -> %s\n", p.Filename, c.String("to"))
+	}
+
+	return nil
+}
+
+func rm(c *cli.Command) error {
+	if c.Args.Len() != 3 {
+		return errors.New("expected package name, version and architecture")
+	}
+
+	lim, err := newLimbo(c)
+	if err != nil {
+		return errors.Wrap(err, "open limbo")
+	}
+
+	err = lim.Load()
+	if err != nil {
+		return errors.Wrap(err, "load pool")
+	}
+
+	removed, err := lim.Remove(c.Args[0], c.Args[1], c.Args[2], "", c.Bool("purge"))
+	if err != nil {
+		return errors.Wrap(err, "remove")
+	}
+
+	for _, r := range removed {
+		fmt.Printf("removed from %s/%s: %s\n", r.Suite, r.Component, r.Filename)
+	}
+
+	return nil
+}
+
+func gc(c *cli.Command) error {
+	lim, err := newLimbo(c)
+	if err != nil {
+		return errors.Wrap(err, "open limbo")
+	}
+
+	err = lim.Load()
+	if err != nil {
+		return errors.Wrap(err, "load pool")
+	}
+
+	dry := c.Bool("dry-run")
+
+	rep, err := lim.GC(dry)
+	if err != nil {
+		return errors.Wrap(err, "gc")
+	}
+
+	verb := "removed"
+	if dry {
+		verb = "would remove"
+	}
+
+	for _, d := range rep.Dropped {
+		fmt.Printf("%s from %s/%s: %s\n", verb, d.Suite, d.Component, d.Filename)
+	}
+
+	for _, fn := range rep.Deleted {
+		fmt.Printf("%s file: %s\n", verb, fn)
+	}
+
+	fmt.Printf("%d references, %d files, %d bytes\n", len(rep.Dropped), len(rep.Deleted), rep.Size)
+
+	return nil
+}
+
+func snapshotCreate(c *cli.Command) error {
+	if c.Args.Len() != 1 {
+		return errors.New("expected snapshot name")
+	}
+
+	lim, err := newLimbo(c)
+	if err != nil {
+		return errors.Wrap(err, "open limbo")
+	}
+
+	err = lim.Load()
+	if err != nil {
+		return errors.Wrap(err, "load pool")
+	}
+
+	s, err := lim.CreateSnapshot(c.String("from"), c.Args.First())
+	if err != nil {
+		return errors.Wrap(err, "create snapshot")
+	}
+
+	fmt.Printf("snapshot %s of %s created at %s\n", s.Name, s.Suite, s.Date.Format(time.RFC3339))
+
+	return nil
+}
+
+func snapshotList(c *cli.Command) error {
+	lim, err := newLimbo(c)
+	if err != nil {
+		return errors.Wrap(err, "open limbo")
+	}
+
+	ss, err := lim.ListSnapshots()
+	if err != nil {
+		return errors.Wrap(err, "list snapshots")
+	}
+
+	for _, s := range ss {
+		fmt.Printf("%-20s %-12s %s\n", s.Name, s.Suite, s.Date.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+func checkDeps(c *cli.Command) error {
+	lim, err := newLimbo(c)
+	if err != nil {
+		return errors.Wrap(err, "open limbo")
+	}
+
+	err = lim.Load()
+	if err != nil {
+		return errors.Wrap(err, "load pool")
+	}
+
+	ext, err := loadExternal(splitList(c.String("external")))
+	if err != nil {
+		return err
+	}
+
+	reps, err := lim.CheckDeps(ext)
+	if err != nil {
+		return errors.Wrap(err, "check deps")
+	}
+
+	for _, r := range reps {
+		for _, b := range r.Broken {
+			fmt.Printf("%s/%s: %s %s %s: %s: %s\n", r.Suite, r.Arch, b.Package, b.Version, b.Architecture, b.Field, b.Relation)
+		}
+	}
+
+	if n := limbo.BrokenCount(reps); n != 0 {
+		return errors.Errorf("%d broken dependencies", n)
+	}
+
+	return nil
+}
+
+func loadExternal(locs []string) (ext []*limbo.Package, err error) {
+	for _, loc := range locs {
+		pkgs, err := limbo.LoadPackagesIndex(loc)
+		if err != nil {
+			return nil, errors.Wrapf(err, "load external %v", loc)
+		}
+
+		ext = append(ext, pkgs...)
+	}
+
+	return ext, nil
+}
+
+func splitList(s string) (l []string) {
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l = append(l, v)
+		}
+	}
+
+	return l
+}
+
+func newLimbo(c *cli.Command) (*limbo.Limbo, error) {
+	ctx := context.Background()
+	ctx = tlog.ContextWithLogger(ctx, tlog.DefaultLogger)
+
+	lim, err := limbo.New(ctx, c.String("path"))
+	if err != nil {
+		return nil, err
+	}
+
+	lim.Suite = c.String("suite")
+	lim.Component = c.String("component")
+
+	lim.Release = limbo.Release{
+		Origin:      c.String("origin"),
+		Label:       c.String("label"),
+		Codename:    c.String("codename"),
+		Description: c.String("description"),
+		ValidFor:    c.Duration("valid-for"),
+	}
+
+	lim.Retention, err = limbo.ParseRetention(c.String("retention"))
+	if err != nil {
+		return nil, errors.Wrap(err, "parse retention")
+	}
+
+	if f := c.String("sign-key"); f != "" {
+		lim.Signer, err = limbo.LoadSigner(f, []byte(c.String("sign-passphrase")))
+		if err != nil {
+			return nil, errors.Wrap(err, "load sign key")
+		}
+	}
+
+	return lim, nil
+}
+
 func debdump(c *cli.Context) error {
 	if c.Args.Len() != 1 {
 		return errors.New("argument expected")
@@ -154,7 +702,7 @@ func debdump(c *cli.Context) error {
 	ctx := context.Background()
 	ctx = tlog.ContextWithLogger(ctx, tlog.DefaultLogger)
 
-	p, err := deb.Open(ctx, c.Args.First())
+	p, err := deb.OpenMeta(ctx, c.Args.First())
 
 	tlog.V("pkg").Printw("package", "p", p)
 
@@ -174,6 +722,18 @@ func debrepack(c *cli.Context) error {
 		return errors.Wrap(err, "open")
 	}
 
+	p.ControlCompression = deb.Compression{
+		Format: c.String("control-compression"),
+		Level:  c.Int("compression-level"),
+	}
+
+	p.DataCompression = deb.Compression{
+		Format: c.String("data-compression"),
+		Level:  c.Int("compression-level"),
+	}
+
+	p.Reproducible = c.Bool("reproducible")
+
 	err = p.Save(c.Args[1])
 	if err != nil {
 		return errors.Wrap(err, "save")
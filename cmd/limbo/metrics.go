@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "limbo",
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "limbo",
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request handling time by route.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"route", "method"})
+
+	httpBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "limbo",
+		Name:      "http_response_bytes_total",
+		Help:      "HTTP response body bytes served by route.",
+	}, []string{"route", "method"})
+)
+
+func init() {
+	prometheus.MustRegister(httpRequests, httpDuration, httpBytes)
+}
+
+// instrument records requests by route pattern and method, so labels are bounded by the routes set.
+func instrument(c *gin.Context) {
+	start := time.Now()
+
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+
+	m := c.Request.Method
+
+	switch m {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodDelete,
+		http.MethodPatch, http.MethodOptions:
+	default:
+		// any method can be sent, keep label values bounded
+		m = "other"
+	}
+
+	httpRequests.WithLabelValues(route, m, strconv.Itoa(c.Writer.Status())).Inc()
+	httpDuration.WithLabelValues(route, m).Observe(time.Since(start).Seconds())
+
+	if n := c.Writer.Size(); n > 0 {
+		httpBytes.WithLabelValues(route, m).Add(float64(n))
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/nikandfor/cli"
+	"github.com/nikandfor/tlog"
+	"github.com/pkg/errors"
+
+	"github.com/rndcenter/limbo/tlsreload"
+)
+
+// newTLSConfig returns nil if no certificate is configured.
+// Certificate is reloaded on SIGHUP and when files change unless polling is disabled by zero interval.
+func newTLSConfig(ctx context.Context, c *cli.Command) (*tls.Config, error) {
+	cert, key := c.String("tls-cert"), c.String("tls-key")
+
+	if cert == "" && key == "" {
+		if c.String("tls-client-ca") != "" {
+			return nil, errors.New("--tls-client-ca requires --tls-cert and --tls-key")
+		}
+
+		return nil, nil
+	}
+
+	if cert == "" || key == "" {
+		return nil, errors.New("both --tls-cert and --tls-key are required")
+	}
+
+	ld, err := tlsreload.New(cert, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "load certificate")
+	}
+
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: ld.GetCertificate,
+	}
+
+	if f := c.String("tls-client-ca"); f != "" {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, errors.Wrap(err, "read client ca")
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.Errorf("no certificates in %v", f)
+		}
+
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	if iv := c.Duration("tls-reload-interval"); iv > 0 {
+		go ld.Watch(ctx, iv, func(err error) {
+			tlog.Printw("tls certificate reload", "err", err)
+		})
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hup)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+			}
+
+			err := ld.Reload()
+			tlog.Printw("tls certificate reload on SIGHUP", "err", err)
+		}
+	}()
+
+	return cfg, nil
+}
+
+// redirectServer redirects plain http requests to https on the port of httpsAddr.
+func redirectServer(addr, httpsAddr string) (*server, error) {
+	_, port, err := net.SplitHostPort(httpsAddr)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse listen address")
+	}
+
+	l, err := listen(1, addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "listen")
+	}
+
+	tlog.Printw("listening http redirect", "addr", l.Addr())
+
+	return &server{
+		Server: &http.Server{
+			Handler:           redirectHandler(port),
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+		l: l,
+	}, nil
+}
+
+func redirectHandler(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+
+		if port != "443" && port != "" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		u := *req.URL
+		u.Scheme = "https"
+		u.Host = host
+
+		http.Redirect(w, req, u.String(), http.StatusMovedPermanently)
+	})
+}
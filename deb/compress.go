@@ -0,0 +1,144 @@
+package deb
+
+import (
+	"compress/gzip"
+	"io"
+	"path"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/nikandfor/errors"
+	"github.com/ulikunitz/xz"
+)
+
+type (
+	// Compression of control.tar or data.tar member.
+	Compression struct {
+		Format string
+
+		// Level is 1-9 for gzip and xz and 1-22 for zstd.
+		// 0 means default for the format, use FormatNone for no compression.
+		Level int
+	}
+
+	nopWriteCloser struct {
+		io.Writer
+	}
+)
+
+// Compression formats.
+const (
+	FormatNone  = "none"
+	FormatGzip  = "gzip"
+	FormatXZ    = "xz"
+	FormatZstd  = "zstd"
+	FormatBzip2 = "bzip2"
+	FormatLZMA  = "lzma"
+)
+
+// xz presets dictionary sizes by level 1-9.
+var xzDictCap = [...]int{
+	1: 1 << 20, 2 << 20, 4 << 20, 4 << 20,
+	8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20,
+}
+
+// formatByName returns compression format of ar member by its name.
+func formatByName(n string) string {
+	switch path.Ext(path.Clean(n)) {
+	case ".gz":
+		return FormatGzip
+	case ".xz":
+		return FormatXZ
+	case ".zst":
+		return FormatZstd
+	case ".bz2":
+		return FormatBzip2
+	case ".lzma":
+		return FormatLZMA
+	default:
+		return FormatNone
+	}
+}
+
+// writeFormat picks write format: c if set, else read format if it's supported for writing.
+func writeFormat(c Compression, read string) Compression {
+	if c.Format != "" {
+		return c
+	}
+
+	switch read {
+	case FormatNone, FormatGzip, FormatXZ, FormatZstd:
+		c.Format = read
+	case FormatBzip2, FormatLZMA:
+		c.Format = FormatXZ
+	default:
+		c.Format = FormatNone
+	}
+
+	return c
+}
+
+// writer returns compressing writer and ar member name extension.
+func (c Compression) writer(w io.Writer) (_ io.WriteCloser, ext string, err error) {
+	switch c.Format {
+	case "", FormatNone:
+		return nopWriteCloser{w}, "", nil
+	case FormatGzip:
+		if c.Level < 0 || c.Level > gzip.BestCompression {
+			return nil, "", errors.New("gzip level out of range 1-%d: %d", gzip.BestCompression, c.Level)
+		}
+
+		l := c.Level
+		if l == 0 {
+			l = gzip.DefaultCompression
+		}
+
+		g, err := gzip.NewWriterLevel(w, l)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "gzip")
+		}
+
+		return g, ".gz", nil
+	case FormatXZ:
+		cfg, err := xzConfig(c.Level)
+		if err != nil {
+			return nil, "", err
+		}
+
+		x, err := cfg.NewWriter(w)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "xz")
+		}
+
+		return x, ".xz", nil
+	case FormatZstd:
+		var opts []zstd.EOption
+
+		if c.Level != 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(c.Level)))
+		}
+
+		z, err := zstd.NewWriter(w, opts...)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "zstd")
+		}
+
+		return z, ".zst", nil
+	default:
+		return nil, "", errors.New("unsupported compression: %v", c.Format)
+	}
+}
+
+// xzConfig returns writer config for xz preset level 1-9, 0 is the library default.
+func xzConfig(level int) (cfg xz.WriterConfig, err error) {
+	if level < 0 || level >= len(xzDictCap) {
+		return cfg, errors.New("xz level out of range 1-%d: %d", len(xzDictCap)-1, level)
+	}
+
+	if level != 0 {
+		cfg.DictCap = xzDictCap[level]
+	}
+
+	return cfg, nil
+}
+
+func (nopWriteCloser) Close() error { return nil }
@@ -4,6 +4,7 @@ import (
 	"archive/tar"
 	"bufio"
 	"bytes"
+	"compress/bzip2"
 	"compress/gzip"
 	"context"
 	"crypto/md5"
@@ -21,8 +22,10 @@ import (
 	"time"
 
 	"github.com/blakesmith/ar"
+	"github.com/klauspost/compress/zstd"
 	"github.com/nikandfor/errors"
 	"github.com/nikandfor/tlog"
+	"github.com/ulikunitz/xz/lzma"
 	"github.com/xi2/xz"
 
 	"github.com/rndcenter/limbo/textproto"
@@ -34,20 +37,31 @@ type (
 		Version       string
 		Architecture  string
 		InstalledSize int64
-		Section       string   `textproto:",omitempty" json:",omitempty"`
-		Priority      string   `textproto:",omitempty" json:",omitempty"`
-		Maintainer    string   `textproto:",omitempty" json:",omitempty"`
-		Vendor        string   `textproto:",omitempty" json:",omitempty"`
-		Depends       []string `textproto:",omitempty" json:",omitempty"`
-		PreDepends    []string `textproto:",omitempty" json:",omitempty"`
-		Recommends    []string `textproto:",omitempty" json:",omitempty"`
-		Homepage      string   `textproto:",omitempty" json:",omitempty"`
-		Description   string   `textproto:",omitempty" json:",omitempty"`
-		Replaces      []string `textproto:",omitempty" json:",omitempty"`
-		Provides      []string `textproto:",omitempty" json:",omitempty"`
-		Conflicts     []string `textproto:",omitempty" json:",omitempty"`
+		Section       string    `textproto:",omitempty" json:",omitempty"`
+		Priority      string    `textproto:",omitempty" json:",omitempty"`
+		Maintainer    string    `textproto:",omitempty" json:",omitempty"`
+		Vendor        string    `textproto:",omitempty" json:",omitempty"`
+		Depends       Relations `textproto:",omitempty" json:",omitempty"`
+		PreDepends    Relations `textproto:",omitempty" json:",omitempty"`
+		Recommends    Relations `textproto:",omitempty" json:",omitempty"`
+		Suggests      Relations `textproto:",omitempty" json:",omitempty"`
+		Enhances      Relations `textproto:",omitempty" json:",omitempty"`
+		Homepage      string    `textproto:",omitempty" json:",omitempty"`
+		Description   string    `textproto:",omitempty" json:",omitempty"`
+		Replaces      Relations `textproto:",omitempty" json:",omitempty"`
+		Provides      Relations `textproto:",omitempty" json:",omitempty"`
+		Conflicts     Relations `textproto:",omitempty" json:",omitempty"`
+		Breaks        Relations `textproto:",omitempty" json:",omitempty"`
+		BuiltUsing    Relations `textproto:",omitempty" json:",omitempty"`
 
 		Rest map[string]interface{} `textproto:",rest" json:"rest,omitempty"`
+
+		// RestOrder is Rest keys in the original order.
+		RestOrder []string `textproto:"-" json:"rest_order,omitempty"`
+
+		// RelationsText is relation fields original text by field name.
+		// It's written instead of the parsed value unless that is changed.
+		RelationsText map[string]string `textproto:"-" json:"relations_text,omitempty"`
 	}
 
 	Package struct {
@@ -58,6 +72,30 @@ type (
 		SHA1Sum   [sha1.Size]byte
 		SHA256Sum [sha256.Size]byte
 
+		// MetaOnly makes ReadFrom to parse control files and data headers only.
+		// Data files are hashed and checked against md5sums but their content is discarded.
+		// Such a package can't be written back.
+		MetaOnly bool
+
+		// Reproducible makes WriteTo output deterministic.
+		// Generated entries get SourceDate timestamp, data files mtimes are clamped to it,
+		// and owners are normalized to root.
+		Reproducible bool
+
+		// SourceDate used in Reproducible mode.
+		// If zero SOURCE_DATE_EPOCH env is used, then control member time of the original package.
+		SourceDate time.Time
+
+		// ControlCompression and DataCompression are used by WriteTo.
+		// Empty Format means to keep the one the package was read with.
+		ControlCompression Compression
+		DataCompression    Compression
+
+		controlFormat string
+		dataFormat    string
+		controlTime   time.Time
+		wtime         time.Time
+
 		files  map[string]*file
 		filesl []*file `tlog:""`
 
@@ -73,6 +111,11 @@ type (
 		Name     string
 		Mode     int64
 		ModTime  time.Time
+		Size     int64
+		Linkname string
+
+		Uid, Gid     int
+		Uname, Gname string
 
 		MD5sum [md5.Size]byte `tlog:",hex"`
 
@@ -81,6 +124,7 @@ type (
 
 	rawControl struct {
 		fs   map[string]*rawField
+		list []*rawField // in struct order
 		rest *rawField
 	}
 
@@ -99,6 +143,8 @@ type (
 
 var rcontrol *rawControl
 
+var relationsType = reflect.TypeOf(Relations{})
+
 func init() {
 	rcontrol = &rawControl{
 		fs: make(map[string]*rawField),
@@ -117,6 +163,10 @@ func init() {
 
 		tg := strings.Split(f.Tag.Get("textproto"), ",")
 
+		if tg[0] == "-" {
+			continue
+		}
+
 		if len(tg) != 0 && tg[0] != "" {
 			rf.Name = tg[0]
 		} else {
@@ -155,6 +205,7 @@ func init() {
 		}
 
 		rcontrol.fs[rf.Name] = &rf
+		rcontrol.list = append(rcontrol.list, &rf)
 	}
 }
 
@@ -175,6 +226,14 @@ func Open(ctx context.Context, fn string) (p *Package, err error) {
 	return p, err
 }
 
+// OpenMeta opens package in MetaOnly mode.
+func OpenMeta(ctx context.Context, fn string) (p *Package, err error) {
+	p = New(ctx)
+	p.MetaOnly = true
+	err = p.Open(fn)
+	return p, err
+}
+
 func (p *Package) Open(fn string) (err error) {
 	p.tr.Printw("open", "basename", filepath.Base(fn), "file", fn)
 
@@ -279,6 +338,9 @@ func (p *Package) readAr(f io.Reader) (err error) {
 		return errors.New("bad deb format: expected control.tar got %s", h.Name)
 	}
 
+	p.controlFormat = formatByName(h.Name)
+	p.controlTime = h.ModTime
+
 	err = p.readTar(h, a, p.readControl)
 	if err != nil {
 		return errors.Wrap(err, "read control")
@@ -293,6 +355,8 @@ func (p *Package) readAr(f io.Reader) (err error) {
 		return errors.New("bad deb format: expected data.tar got %s", h.Name)
 	}
 
+	p.dataFormat = formatByName(h.Name)
+
 	err = p.readTar(h, a, p.readFsys)
 	if err != nil {
 		return errors.Wrap(err, "read fsys")
@@ -303,11 +367,12 @@ func (p *Package) readAr(f io.Reader) (err error) {
 		if err == io.EOF {
 			return nil
 		}
+		if err != nil {
+			return errors.Wrap(err, "read ar header")
+		}
 
 		p.tr.Printw("unused file in ar", "type", "", "size", h.Size, "name", h.Name)
 	}
-
-	return nil
 }
 
 func (p *Package) readTar(h *ar.Header, r io.Reader, f func(h *tar.Header, r io.Reader) error) (err error) {
@@ -346,6 +411,32 @@ again:
 		r = x
 		n = strings.TrimSuffix(n, ext)
 
+		goto again
+	case ".zst":
+		z, err := zstd.NewReader(r)
+		if err != nil {
+			return errors.Wrap(err, "open zstd")
+		}
+		defer z.Close()
+
+		r = z
+		n = strings.TrimSuffix(n, ext)
+
+		goto again
+	case ".bz2":
+		r = bzip2.NewReader(r)
+		n = strings.TrimSuffix(n, ext)
+
+		goto again
+	case ".lzma":
+		l, err := lzma.NewReader(r)
+		if err != nil {
+			return errors.Wrap(err, "open lzma")
+		}
+
+		r = l
+		n = strings.TrimSuffix(n, ext)
+
 		goto again
 	default:
 		return errors.New("unsupported file format: %q", ext)
@@ -428,6 +519,10 @@ func (c *Control) ReadFrom(r io.Reader) (n int64, err error) {
 				c.Rest = make(map[string]interface{})
 			}
 
+			if _, ok := c.Rest[string(name)]; !ok {
+				c.RestOrder = append(c.RestOrder, string(name))
+			}
+
 			c.Rest[string(name)] = string(val)
 
 			continue
@@ -435,6 +530,23 @@ func (c *Control) ReadFrom(r io.Reader) (n int64, err error) {
 
 		f := v.Field(fr.I)
 
+		if f.Type() == relationsType {
+			rs, err := ParseRelations(string(val))
+			if err != nil {
+				return n, errors.Wrap(err, "parse %s", name)
+			}
+
+			f.Set(reflect.ValueOf(rs))
+
+			if c.RelationsText == nil {
+				c.RelationsText = make(map[string]string)
+			}
+
+			c.RelationsText[string(name)] = string(val)
+
+			continue
+		}
+
 		switch f.Kind() {
 		case reflect.String:
 			f.SetString(string(val))
@@ -498,12 +610,28 @@ func (p *Package) parseMD5Sums(r io.Reader) (err error) {
 	return nil
 }
 
-func (p *Package) readFsys(h *tar.Header, r io.Reader) error {
+func (p *Package) readFsys(h *tar.Header, r io.Reader) (err error) {
 	p.tr.V("fileheader").Printw("fsys file", "type", tlog.FormatNext("%c"), h.Typeflag, "size", h.Size, "name", h.Name)
 
-	data, err := ioutil.ReadAll(r)
-	if err != nil {
-		return errors.Wrap(err, "read file content")
+	var data []byte
+	var md5sum [md5.Size]byte
+
+	if p.MetaOnly {
+		hs := md5.New()
+
+		_, err = io.Copy(hs, r)
+		if err != nil {
+			return errors.Wrap(err, "read file content")
+		}
+
+		_ = hs.Sum(md5sum[:0])
+	} else {
+		data, err = ioutil.ReadAll(r)
+		if err != nil {
+			return errors.Wrap(err, "read file content")
+		}
+
+		md5sum = md5.Sum(data)
 	}
 
 	var f *file
@@ -515,7 +643,6 @@ func (p *Package) readFsys(h *tar.Header, r io.Reader) error {
 			p.tr.Printw("no md5sum", "file", h.Name)
 		}
 
-		md5sum := md5.Sum(data)
 		if f != nil && f.MD5sum != md5sum {
 			//	return errors.New("%v: md5sum mismatch", h.Name)
 
@@ -529,6 +656,10 @@ func (p *Package) readFsys(h *tar.Header, r io.Reader) error {
 	f.Typeflag = h.Typeflag
 	f.Mode = h.Mode
 	f.ModTime = h.ModTime
+	f.Size = h.Size
+	f.Linkname = h.Linkname
+	f.Uid, f.Gid = h.Uid, h.Gid
+	f.Uname, f.Gname = h.Uname, h.Gname
 
 	f.data = data
 
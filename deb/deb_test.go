@@ -1,5 +1,407 @@
 package deb
 
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blakesmith/ar"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	uxz "github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
 func TestHash(t *testing.T) {
-	p := &Package{}
+	p := New(context.Background())
+
+	p.Control = Control{
+		Package:      "pkg",
+		Version:      "1.0",
+		Architecture: "amd64",
+	}
+
+	var b bytes.Buffer
+
+	n, err := p.WriteTo(&b)
+	require.NoError(t, err)
+	assert.Equal(t, int64(b.Len()), n)
+
+	r := New(context.Background())
+
+	m, err := r.ReadFrom(&b)
+	require.NoError(t, err)
+
+	assert.Equal(t, n, m)
+	assert.Equal(t, p.MD5Sum, r.MD5Sum)
+	assert.Equal(t, p.SHA1Sum, r.SHA1Sum)
+	assert.Equal(t, p.SHA256Sum, r.SHA256Sum)
+	assert.Equal(t, p.Control, r.Control)
+}
+
+func TestMetaOnly(t *testing.T) {
+	p := New(context.Background())
+
+	p.Control = Control{
+		Package:      "pkg",
+		Version:      "1.0",
+		Architecture: "amd64",
+	}
+
+	data := []byte("file content\n")
+
+	f := p.file("usr/share/doc/pkg/README")
+	f.Typeflag = tar.TypeReg
+	f.Mode = 0644
+	f.data = data
+	p.filesl = append(p.filesl, f)
+
+	var b bytes.Buffer
+
+	_, err := p.WriteTo(&b)
+	require.NoError(t, err)
+
+	r := New(context.Background())
+	r.MetaOnly = true
+
+	_, err = r.ReadFrom(bytes.NewReader(b.Bytes()))
+	require.NoError(t, err)
+
+	assert.Equal(t, p.SHA256Sum, r.SHA256Sum)
+
+	if assert.Len(t, r.filesl, 1) {
+		f := r.filesl[0]
+
+		assert.Equal(t, "usr/share/doc/pkg/README", f.Name)
+		assert.Equal(t, int64(len(data)), f.Size)
+		assert.Nil(t, f.data)
+	}
+
+	_, err = r.WriteTo(&b)
+	assert.Error(t, err)
+}
+
+func TestReadCompressed(t *testing.T) {
+	p := New(context.Background())
+
+	p.Control = Control{
+		Package:      "pkg",
+		Version:      "1.0",
+		Architecture: "amd64",
+	}
+
+	var b bytes.Buffer
+
+	_, err := p.WriteTo(&b)
+	require.NoError(t, err)
+
+	for ext, c := range map[string]func(w io.Writer) (io.WriteCloser, error){
+		".gz":   func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+		".xz":   func(w io.Writer) (io.WriteCloser, error) { return uxz.NewWriter(w) },
+		".zst":  func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) },
+		".lzma": func(w io.Writer) (io.WriteCloser, error) { return lzma.NewWriter(w) },
+	} {
+		data := recompressMembers(t, b.Bytes(), ext, c)
+
+		r := New(context.Background())
+
+		_, err = r.ReadFrom(bytes.NewReader(data))
+		if assert.NoError(t, err, ext) {
+			assert.Equal(t, p.Control, r.Control, ext)
+		}
+	}
+}
+
+func TestReadBzip2(t *testing.T) {
+	// control.tar.gz and data.tar.bz2 built with tar, gzip, bzip2 and ar
+	p, err := Open(context.Background(), "testdata/bzip2_1.0_amd64.deb")
+	require.NoError(t, err)
+
+	assert.Equal(t, "pkg", p.Control.Package)
+	assert.Equal(t, "bzip2 test package", p.Control.Description)
+	assert.Equal(t, FormatBzip2, p.dataFormat)
+
+	f := p.files["usr/share/doc/pkg/README"]
+	if assert.NotNil(t, f) {
+		assert.Equal(t, "hello\n", string(f.data))
+	}
+
+	// bzip2 can't be written, so it's recompressed
+	var b bytes.Buffer
+
+	_, err = p.WriteTo(&b)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"debian-binary", "control.tar.gz", "data.tar.xz"}, arMembers(t, b.Bytes()))
+
+	r := New(context.Background())
+
+	_, err = r.ReadFrom(&b)
+	require.NoError(t, err)
+
+	assert.Equal(t, p.Control, r.Control)
+
+	f = r.files["usr/share/doc/pkg/README"]
+	if assert.NotNil(t, f) {
+		assert.Equal(t, "hello\n", string(f.data))
+	}
+}
+
+func recompressMembers(t testing.TB, data []byte, ext string, c func(w io.Writer) (io.WriteCloser, error)) []byte {
+	t.Helper()
+
+	var b, m bytes.Buffer
+
+	r := ar.NewReader(bytes.NewReader(data))
+	w := ar.NewWriter(&b)
+
+	err := w.WriteGlobalHeader()
+	require.NoError(t, err)
+
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		m.Reset()
+
+		if strings.HasSuffix(h.Name, ".tar") {
+			cw, err := c(&m)
+			require.NoError(t, err)
+
+			_, err = io.Copy(cw, r)
+			require.NoError(t, err)
+
+			err = cw.Close()
+			require.NoError(t, err)
+
+			h.Name += ext
+		} else {
+			_, err = m.ReadFrom(r)
+			require.NoError(t, err)
+		}
+
+		h.Size = int64(m.Len())
+
+		err = w.WriteHeader(h)
+		require.NoError(t, err)
+
+		_, err = w.Write(m.Bytes())
+		require.NoError(t, err)
+	}
+
+	return b.Bytes()
+}
+
+func TestWriteCompression(t *testing.T) {
+	p := New(context.Background())
+
+	p.Control = Control{
+		Package:      "pkg",
+		Version:      "1.0",
+		Architecture: "amd64",
+	}
+
+	p.ControlCompression = Compression{Format: FormatXZ}
+	p.DataCompression = Compression{Format: FormatZstd, Level: 19}
+
+	var b bytes.Buffer
+
+	_, err := p.WriteTo(&b)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"debian-binary", "control.tar.xz", "data.tar.zst"}, arMembers(t, b.Bytes()))
+
+	r := New(context.Background())
+
+	_, err = r.ReadFrom(&b)
+	require.NoError(t, err)
+
+	assert.Equal(t, p.Control, r.Control)
+
+	// keep original compression
+	b.Reset()
+
+	_, err = r.WriteTo(&b)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"debian-binary", "control.tar.xz", "data.tar.zst"}, arMembers(t, b.Bytes()))
+
+	r.ControlCompression = Compression{Format: FormatGzip}
+	r.DataCompression = Compression{Format: FormatNone}
+
+	b.Reset()
+
+	_, err = r.WriteTo(&b)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"debian-binary", "control.tar.gz", "data.tar"}, arMembers(t, b.Bytes()))
+}
+
+func arMembers(t testing.TB, data []byte) (l []string) {
+	t.Helper()
+
+	r := ar.NewReader(bytes.NewReader(data))
+
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			return l
+		}
+		require.NoError(t, err)
+
+		l = append(l, h.Name)
+	}
+}
+
+func TestReproducible(t *testing.T) {
+	// clock must not leak into the output
+	clock := time.Unix(1700000000, 0)
+
+	defer func() { now = time.Now }()
+	now = func() time.Time {
+		clock = clock.Add(time.Hour)
+		return clock
+	}
+
+	build := func() *Package {
+		p := New(context.Background())
+
+		p.Control = Control{
+			Package:      "pkg",
+			Version:      "1.0",
+			Architecture: "amd64",
+			Rest: map[string]interface{}{
+				"Multi-Arch": "same",
+				"Source":     "pkg-src",
+			},
+		}
+
+		p.RestControls = map[string]interface{}{
+			"postinst":  "#!/bin/sh\n",
+			"prerm":     "#!/bin/sh\n",
+			"conffiles": "/etc/pkg.conf\n",
+		}
+
+		f := p.file("etc/pkg.conf")
+		f.Typeflag = tar.TypeReg
+		f.Mode = 0644
+		f.ModTime = now()
+		f.Uid, f.Uname = 1000, "user"
+		f.data = []byte("key = value\n")
+		p.filesl = append(p.filesl, f)
+
+		p.Reproducible = true
+		p.DataCompression = Compression{Format: FormatGzip}
+
+		return p
+	}
+
+	defer os.Unsetenv("SOURCE_DATE_EPOCH")
+	os.Setenv("SOURCE_DATE_EPOCH", "1600000000")
+
+	var a, b bytes.Buffer
+
+	_, err := build().WriteTo(&a)
+	require.NoError(t, err)
+
+	_, err = build().WriteTo(&b)
+	require.NoError(t, err)
+
+	assert.Equal(t, a.Bytes(), b.Bytes())
+
+	r := New(context.Background())
+
+	_, err = r.ReadFrom(bytes.NewReader(a.Bytes()))
+	require.NoError(t, err)
+
+	if assert.Len(t, r.filesl, 1) {
+		f := r.filesl[0]
+
+		assert.Equal(t, "root", f.Uname)
+		assert.Equal(t, 0, f.Uid)
+		assert.Equal(t, int64(1600000000), f.ModTime.Unix())
+	}
+
+	r.Reproducible = true
+
+	b.Reset()
+
+	_, err = r.WriteTo(&b)
+	require.NoError(t, err)
+
+	assert.Equal(t, a.Bytes(), b.Bytes())
+}
+
+func TestControlFieldOrder(t *testing.T) {
+	var c Control
+
+	_, err := c.ReadFrom(strings.NewReader(`Description: short
+ long
+Maintainer: Someone <someone@example.com>
+X-Zeta: z
+Version: 1.0
+Multi-Arch: foreign
+X-Alpha: a
+Architecture: amd64
+Depends: libc6 (>= 2.17), zlib1g
+Package: pkg
+Installed-Size: 10
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"X-Zeta", "Multi-Arch", "X-Alpha"}, c.RestOrder)
+
+	var b bytes.Buffer
+
+	_, err = c.WriteTo(&b)
+	require.NoError(t, err)
+
+	assert.Equal(t, `Package: pkg
+Version: 1.0
+Architecture: amd64
+Maintainer: Someone <someone@example.com>
+Installed-Size: 10
+Multi-Arch: foreign
+Depends: libc6 (>= 2.17), zlib1g
+X-Zeta: z
+X-Alpha: a
+Description: short
+ long
+`, b.String())
+}
+
+func TestXZLevel(t *testing.T) {
+	cfg, err := xzConfig(1)
+	require.NoError(t, err)
+	assert.Equal(t, 1<<20, cfg.DictCap)
+
+	cfg, err = xzConfig(9)
+	require.NoError(t, err)
+	assert.Equal(t, 64<<20, cfg.DictCap)
+
+	cfg, err = xzConfig(0)
+	require.NoError(t, err)
+	assert.Zero(t, cfg.DictCap)
+
+	for _, l := range []int{-1, 10} {
+		_, err = xzConfig(l)
+		assert.Error(t, err, "level %d", l)
+
+		_, _, err = Compression{Format: FormatXZ, Level: l}.writer(ioutil.Discard)
+		assert.Error(t, err, "level %d", l)
+
+		_, _, err = Compression{Format: FormatGzip, Level: l}.writer(ioutil.Discard)
+		assert.Error(t, err, "gzip level %d", l)
+	}
 }
@@ -9,6 +9,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -44,8 +45,14 @@ func (p *Package) Save(fn string) (err error) {
 }
 
 func (p *Package) WriteTo(w io.Writer) (n int64, err error) {
+	if p.MetaOnly {
+		return 0, errors.New("package opened in meta only mode")
+	}
+
 	p.tr.Printw("write to writer", "package", p.Control.Package, "version", p.Control.Version, "arch", p.Control.Architecture)
 
+	p.wtime = p.writeTime()
+
 	w, sum := p.writeHash(w)
 
 	err = p.writeAr(w)
@@ -110,12 +117,12 @@ func (p *Package) writeAr(w io.Writer) (err error) {
 		return errors.Wrap(err, "write deb version (content)")
 	}
 
-	err = p.writeTar(a, "control.tar", p.writeControl)
+	err = p.writeTar(a, "control.tar", writeFormat(p.ControlCompression, p.controlFormat), p.writeControl)
 	if err != nil {
 		return errors.Wrap(err, "write control")
 	}
 
-	err = p.writeTar(a, "data.tar", p.writeFsys)
+	err = p.writeTar(a, "data.tar", writeFormat(p.DataCompression, p.dataFormat), p.writeFsys)
 	if err != nil {
 		return errors.Wrap(err, "write data")
 	}
@@ -123,10 +130,15 @@ func (p *Package) writeAr(w io.Writer) (err error) {
 	return nil
 }
 
-func (p *Package) writeTar(w *ar.Writer, n string, f func(w *tar.Writer) error) (err error) {
+func (p *Package) writeTar(w *ar.Writer, n string, c Compression, f func(w *tar.Writer) error) (err error) {
 	p.b.Reset()
 
-	a := tar.NewWriter(&p.b)
+	cw, ext, err := c.writer(&p.b)
+	if err != nil {
+		return errors.Wrap(err, "compressor")
+	}
+
+	a := tar.NewWriter(cw)
 
 	err = f(a)
 	if err != nil {
@@ -138,9 +150,14 @@ func (p *Package) writeTar(w *ar.Writer, n string, f func(w *tar.Writer) error)
 		return errors.Wrap(err, "close tar")
 	}
 
+	err = cw.Close()
+	if err != nil {
+		return errors.Wrap(err, "close compressor")
+	}
+
 	h := ar.Header{
-		Name:    n,
-		ModTime: time.Now(),
+		Name:    n + ext,
+		ModTime: p.wtime,
 		Mode:    0544,
 		Size:    int64(p.b.Len()),
 	}
@@ -163,14 +180,23 @@ func (p *Package) writeTar(w *ar.Writer, n string, f func(w *tar.Writer) error)
 }
 
 func (p *Package) writeControl(w *tar.Writer) (err error) {
-	now := time.Now()
+	now := p.wtime
 
 	err = p.writeControlControl(w, now)
 	if err != nil {
 		return errors.Wrap(err, "write control control")
 	}
 
-	for name, data := range p.RestControls {
+	names := make([]string, 0, len(p.RestControls))
+	for name := range p.RestControls {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		data := p.RestControls[name]
+
 		if name == "" {
 			return errors.New("empty rest control name")
 		}
@@ -194,6 +220,8 @@ func (p *Package) writeControl(w *tar.Writer) (err error) {
 			Size:     int64(p.b2.Len()),
 		}
 
+		p.normalizeHeader(&h)
+
 		err = w.WriteHeader(&h)
 		if err != nil {
 			return errors.Wrap(err, "write %v header", name)
@@ -227,6 +255,8 @@ func (p *Package) writeControlControl(w *tar.Writer, now time.Time) (err error)
 		Size:     int64(p.b2.Len()),
 	}
 
+	p.normalizeHeader(&h)
+
 	err = w.WriteHeader(&h)
 	if err != nil {
 		return errors.Wrap(err, "write header")
@@ -240,37 +270,105 @@ func (p *Package) writeControlControl(w *tar.Writer, now time.Time) (err error)
 	return err
 }
 
+// fieldOrder is the canonical binary control fields order.
+// Description goes last, unknown fields go before it in the original order.
+var fieldOrder = []string{
+	"Package",
+	"Package-Type",
+	"Source",
+	"Version",
+	"Built-Using",
+	"Kernel-Version",
+	"Built-For-Profiles",
+	"Auto-Built-Package",
+	"Architecture",
+	"Subarchitecture",
+	"Installer-Menu-Item",
+	"Build-Essential",
+	"Essential",
+	"Protected",
+	"Origin",
+	"Bugs",
+	"Vendor",
+	"Maintainer",
+	"Installed-Size",
+	"Section",
+	"Priority",
+	"Multi-Arch",
+	"Homepage",
+	"Pre-Depends",
+	"Depends",
+	"Recommends",
+	"Suggests",
+	"Enhances",
+	"Conflicts",
+	"Breaks",
+	"Replaces",
+	"Provides",
+}
+
+// relationsText returns the original field text if rs is the same as parsed from it.
+func (c *Control) relationsText(name string, rs Relations) string {
+	s := rs.String()
+
+	text, ok := c.RelationsText[name]
+	if !ok {
+		return s
+	}
+
+	orig, err := ParseRelations(text)
+	if err != nil || orig.String() != s {
+		return s
+	}
+
+	return text
+}
+
 func (c *Control) WriteTo(w io.Writer) (n int64, err error) {
 	tw := textproto.NewWriter(io.MultiWriter(
 		w,
 		counter{&n},
 	))
 
+	vals := make(map[string]string, len(rcontrol.list)+len(c.Rest))
+
 	r := reflect.ValueOf(c).Elem()
 
-	for _, f := range rcontrol.fs {
+	for _, f := range rcontrol.list {
 		fv := r.Field(f.I)
 		if f.OmitEmpty && fv.IsZero() {
 			continue
 		}
 
-		err = tw.KeyString(f.Name)
-		if err != nil {
-			return
-		}
-
 		switch v := fv.Interface().(type) {
 		case string:
-			err = tw.ValueString(v)
+			vals[f.Name] = v
 		case []string:
-			l := strings.Join(v, ", ")
-
-			err = tw.ValueString(l)
+			vals[f.Name] = strings.Join(v, ", ")
+		case Relations:
+			vals[f.Name] = c.relationsText(f.Name, v)
 		case int64:
-			err = tw.ValueString(strconv.FormatInt(v, 10))
+			vals[f.Name] = strconv.FormatInt(v, 10)
 		default:
-			err = errors.New("unsupported type: %T", v)
+			return n, errors.New("unsupported type: %T", v)
 		}
+	}
+
+	for k, v := range c.Rest {
+		if _, ok := vals[k]; ok {
+			continue
+		}
+
+		s, ok := v.(string)
+		if !ok {
+			return n, errors.New("unsupported rest field type: %v: %T", k, v)
+		}
+
+		vals[k] = s
+	}
+
+	for _, k := range c.fieldNames(vals) {
+		err = tw.PairStrings(k, vals[k])
 		if err != nil {
 			return
 		}
@@ -279,16 +377,66 @@ func (c *Control) WriteTo(w io.Writer) (n int64, err error) {
 	return n, nil
 }
 
+// fieldNames returns vals keys in the canonical order.
+func (c *Control) fieldNames(vals map[string]string) []string {
+	names := make([]string, 0, len(vals))
+	seen := make(map[string]bool, len(vals))
+
+	add := func(k string) {
+		if _, ok := vals[k]; !ok || seen[k] {
+			return
+		}
+
+		seen[k] = true
+		names = append(names, k)
+	}
+
+	for _, k := range fieldOrder {
+		add(k)
+	}
+
+	for _, k := range c.RestOrder {
+		if k != "Description" {
+			add(k)
+		}
+	}
+
+	var tail []string
+
+	for k := range vals {
+		if !seen[k] && k != "Description" {
+			tail = append(tail, k)
+		}
+	}
+
+	sort.Strings(tail)
+
+	for _, k := range tail {
+		add(k)
+	}
+
+	add("Description")
+
+	return names
+}
+
 func (p *Package) writeFsys(w *tar.Writer) (err error) {
 	for _, f := range p.filesl {
 		h := tar.Header{
 			Typeflag: f.Typeflag,
 			Name:     f.Name,
+			Linkname: f.Linkname,
 			Mode:     f.Mode,
 			ModTime:  f.ModTime,
 			Size:     int64(len(f.data)),
+			Uid:      f.Uid,
+			Gid:      f.Gid,
+			Uname:    f.Uname,
+			Gname:    f.Gname,
 		}
 
+		p.normalizeHeader(&h)
+
 		err = w.WriteHeader(&h)
 		if err != nil {
 			return errors.Wrap(err, "write %v header", f.Name)
@@ -302,3 +450,56 @@ func (p *Package) writeFsys(w *tar.Writer) (err error) {
 
 	return nil
 }
+
+// now is replaced in tests.
+var now = time.Now
+
+// writeTime returns timestamp for generated entries.
+func (p *Package) writeTime() time.Time {
+	if !p.Reproducible {
+		return now()
+	}
+
+	if !p.SourceDate.IsZero() {
+		return p.SourceDate
+	}
+
+	if t, ok := SourceDateEpoch(); ok {
+		return t
+	}
+
+	if !p.controlTime.IsZero() {
+		return p.controlTime
+	}
+
+	return time.Unix(0, 0)
+}
+
+// normalizeHeader makes tar header deterministic in Reproducible mode.
+func (p *Package) normalizeHeader(h *tar.Header) {
+	if !p.Reproducible {
+		return
+	}
+
+	h.Uid, h.Gid = 0, 0
+	h.Uname, h.Gname = "root", "root"
+
+	if h.ModTime.After(p.wtime) {
+		h.ModTime = p.wtime
+	}
+}
+
+// SourceDateEpoch returns time from SOURCE_DATE_EPOCH env var if it's set.
+func SourceDateEpoch() (time.Time, bool) {
+	v := os.Getenv("SOURCE_DATE_EPOCH")
+	if v == "" {
+		return time.Time{}, false
+	}
+
+	sec, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return time.Unix(sec, 0), true
+}
@@ -0,0 +1,325 @@
+package deb
+
+import (
+	"strings"
+
+	"github.com/nikandfor/errors"
+)
+
+type (
+	// Relations is a dependency field value: comma separated list of Alternatives.
+	// All of them must be satisfied.
+	//
+	//	libc6 (>= 2.17), default-mta | mail-transport-agent
+	Relations []Alternatives
+
+	// Alternatives is a pipe separated list of Relation.
+	// Any of them must be satisfied.
+	Alternatives []Relation
+
+	// Relation is a single package reference.
+	//
+	//	name[:archqual] [(op version)] [[arch ...]] [<profile ...>]...
+	Relation struct {
+		Name     string
+		ArchQual string `json:",omitempty"` // any, native or arch name
+
+		Op      string `json:",omitempty"` // <<, <=, =, >=, >>
+		Version string `json:",omitempty"`
+
+		Archs    []string   `json:",omitempty"` // [amd64 !i386]
+		Profiles [][]string `json:",omitempty"` // <!nocheck> <stage1 cross>
+	}
+)
+
+// Relation operators.
+const (
+	OpLess      = "<<"
+	OpLessEq    = "<="
+	OpEq        = "="
+	OpGreaterEq = ">="
+	OpGreater   = ">>"
+)
+
+// ParseRelations parses dependency field value.
+func ParseRelations(s string) (rs Relations, err error) {
+	for _, part := range strings.Split(s, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+
+		var alt Alternatives
+
+		for _, rp := range strings.Split(part, "|") {
+			r, err := ParseRelation(rp)
+			if err != nil {
+				return nil, errors.Wrap(err, "parse %q", strings.TrimSpace(part))
+			}
+
+			alt = append(alt, r)
+		}
+
+		rs = append(rs, alt)
+	}
+
+	return rs, nil
+}
+
+// ParseRelation parses single relation.
+func ParseRelation(s string) (r Relation, err error) {
+	s = strings.TrimSpace(s)
+
+	i := strings.IndexAny(s, " \t\n:([<")
+	if i == -1 {
+		i = len(s)
+	}
+
+	r.Name = s[:i]
+	if r.Name == "" {
+		return r, errors.New("empty package name")
+	}
+
+	s = strings.TrimSpace(s[i:])
+
+	if strings.HasPrefix(s, ":") {
+		s = s[1:]
+
+		i = strings.IndexAny(s, " \t\n([<")
+		if i == -1 {
+			i = len(s)
+		}
+
+		r.ArchQual = s[:i]
+		if r.ArchQual == "" {
+			return r, errors.New("empty arch qualifier")
+		}
+
+		s = strings.TrimSpace(s[i:])
+	}
+
+	if strings.HasPrefix(s, "(") {
+		var in string
+
+		in, s, err = cutGroup(s, '(', ')')
+		if err != nil {
+			return r, err
+		}
+
+		i = 0
+		for i < len(in) && strings.IndexByte("<>=", in[i]) != -1 {
+			i++
+		}
+
+		r.Op = in[:i]
+		r.Version = strings.TrimSpace(in[i:])
+
+		switch r.Op {
+		case OpLess, OpLessEq, OpEq, OpGreaterEq, OpGreater, "<", ">":
+		default:
+			return r, errors.New("bad version operator: %q", r.Op)
+		}
+
+		if r.Version == "" {
+			return r, errors.New("empty version")
+		}
+	}
+
+	if strings.HasPrefix(s, "[") {
+		var in string
+
+		in, s, err = cutGroup(s, '[', ']')
+		if err != nil {
+			return r, err
+		}
+
+		r.Archs = strings.Fields(in)
+		if len(r.Archs) == 0 {
+			return r, errors.New("empty arch list")
+		}
+	}
+
+	for strings.HasPrefix(s, "<") {
+		var in string
+
+		in, s, err = cutGroup(s, '<', '>')
+		if err != nil {
+			return r, err
+		}
+
+		p := strings.Fields(in)
+		if len(p) == 0 {
+			return r, errors.New("empty build profile")
+		}
+
+		r.Profiles = append(r.Profiles, p)
+	}
+
+	if s != "" {
+		return r, errors.New("unexpected %q", s)
+	}
+
+	return r, nil
+}
+
+// cutGroup returns content of group s starts with and the rest.
+func cutGroup(s string, open, cls byte) (in, rest string, err error) {
+	end := strings.IndexByte(s, cls)
+	if end == -1 {
+		return "", "", errors.New("unclosed %c", open)
+	}
+
+	return strings.TrimSpace(s[1:end]), strings.TrimSpace(s[end+1:]), nil
+}
+
+func (rs Relations) String() string {
+	var b strings.Builder
+
+	for i, alt := range rs {
+		if i != 0 {
+			b.WriteString(", ")
+		}
+
+		alt.appendTo(&b)
+	}
+
+	return b.String()
+}
+
+func (alt Alternatives) String() string {
+	var b strings.Builder
+
+	alt.appendTo(&b)
+
+	return b.String()
+}
+
+func (alt Alternatives) appendTo(b *strings.Builder) {
+	for i, r := range alt {
+		if i != 0 {
+			b.WriteString(" | ")
+		}
+
+		r.appendTo(b)
+	}
+}
+
+func (r Relation) String() string {
+	var b strings.Builder
+
+	r.appendTo(&b)
+
+	return b.String()
+}
+
+func (r Relation) appendTo(b *strings.Builder) {
+	b.WriteString(r.Name)
+
+	if r.ArchQual != "" {
+		b.WriteByte(':')
+		b.WriteString(r.ArchQual)
+	}
+
+	if r.Op != "" {
+		b.WriteString(" (")
+		b.WriteString(r.Op)
+		b.WriteByte(' ')
+		b.WriteString(r.Version)
+		b.WriteByte(')')
+	}
+
+	if len(r.Archs) != 0 {
+		b.WriteString(" [")
+		b.WriteString(strings.Join(r.Archs, " "))
+		b.WriteByte(']')
+	}
+
+	for _, p := range r.Profiles {
+		b.WriteString(" <")
+		b.WriteString(strings.Join(p, " "))
+		b.WriteByte('>')
+	}
+}
+
+// Names returns all package names mentioned.
+func (rs Relations) Names() (l []string) {
+	for _, alt := range rs {
+		for _, r := range alt {
+			l = append(l, r.Name)
+		}
+	}
+
+	return l
+}
+
+func (rs Relations) MarshalText() ([]byte, error) {
+	return []byte(rs.String()), nil
+}
+
+func (rs *Relations) UnmarshalText(data []byte) (err error) {
+	*rs, err = ParseRelations(string(data))
+	return err
+}
+
+// Match reports whether package name of version v satisfies r.
+// Architecture restrictions and qualifiers are not checked.
+func (r Relation) Match(name string, v Version) bool {
+	if r.Name != name {
+		return false
+	}
+
+	if r.Op == "" {
+		return true
+	}
+
+	rv, err := ParseVersion(r.Version)
+	if err != nil {
+		return false
+	}
+
+	return compareOp(v.Compare(rv), r.Op)
+}
+
+// AppliesTo reports whether r is relevant for architecture arch.
+// Relations without arch restriction apply to any arch.
+func (r Relation) AppliesTo(arch string) bool {
+	if len(r.Archs) == 0 {
+		return true
+	}
+
+	neg := false
+
+	for _, a := range r.Archs {
+		if strings.HasPrefix(a, "!") {
+			neg = true
+
+			if a[1:] == arch {
+				return false
+			}
+
+			continue
+		}
+
+		if a == arch {
+			return true
+		}
+	}
+
+	return neg
+}
+
+func compareOp(c int, op string) bool {
+	switch op {
+	case OpLess:
+		return c < 0
+	case OpLessEq, "<":
+		return c <= 0
+	case OpEq:
+		return c == 0
+	case OpGreaterEq, ">":
+		return c >= 0
+	case OpGreater:
+		return c > 0
+	default:
+		return false
+	}
+}
@@ -0,0 +1,146 @@
+package deb
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRelationsRoundTrip(t *testing.T) {
+	for _, s := range []string{
+		"libc6",
+		"libc6 (>= 2.17)",
+		"libc6 (>= 2.17), zlib1g (>= 1:1.1.4)",
+		"default-mta | mail-transport-agent",
+		"python3:any (>= 3.6~)",
+		"libfoo (<< 2.0) [amd64 !i386]",
+		"debhelper-compat (= 13), dh-python <!nocheck>, python3-pytest <!nocheck> <cross>",
+		"gcc [linux-any] <stage1 !nocheck>",
+		"a | b:native (>> 1) | c [!hurd-any] <!stage2>, d (<= 1.0-1), e (= 2:3)",
+	} {
+		rs, err := ParseRelations(s)
+		if assert.NoError(t, err, s) {
+			assert.Equal(t, s, rs.String(), s)
+		}
+	}
+}
+
+func TestParseRelations(t *testing.T) {
+	rs, err := ParseRelations("libc6 (>=2.17),\n python3:any(>= 3.6~)  |  python3-minimal [amd64 !i386] <!nocheck> <cross>,")
+	require.NoError(t, err)
+
+	assert.Equal(t, Relations{
+		{
+			{Name: "libc6", Op: OpGreaterEq, Version: "2.17"},
+		},
+		{
+			{Name: "python3", ArchQual: "any", Op: OpGreaterEq, Version: "3.6~"},
+			{Name: "python3-minimal", Archs: []string{"amd64", "!i386"}, Profiles: [][]string{{"!nocheck"}, {"cross"}}},
+		},
+	}, rs)
+
+	assert.Equal(t, "libc6 (>= 2.17), python3:any (>= 3.6~) | python3-minimal [amd64 !i386] <!nocheck> <cross>", rs.String())
+
+	assert.Equal(t, []string{"libc6", "python3", "python3-minimal"}, rs.Names())
+
+	for _, s := range []string{
+		"(>= 1.0)",
+		"a (>= 1.0",
+		"a (~ 1.0)",
+		"a (>=)",
+		"a []",
+		"a <>",
+		"a:",
+		"a b",
+	} {
+		_, err := ParseRelations(s)
+		assert.Error(t, err, "%q", s)
+	}
+}
+
+func TestControlRelations(t *testing.T) {
+	var c Control
+
+	_, err := c.ReadFrom(strings.NewReader("Package: pkg\nDepends: libc6 (>= 2.17) | libc6.1\nBreaks: old (<< 1.0)\nBuilt-Using: gcc-10 (= 10.2.0-5)\n"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "libc6 (>= 2.17) | libc6.1", c.Depends.String())
+	assert.Equal(t, "old (<< 1.0)", c.Breaks.String())
+	assert.Equal(t, "gcc-10 (= 10.2.0-5)", c.BuiltUsing.String())
+	assert.Empty(t, c.Rest)
+
+	data, err := json.Marshal(c)
+	require.NoError(t, err)
+
+	var c2 Control
+
+	err = json.Unmarshal(data, &c2)
+	require.NoError(t, err)
+
+	assert.Equal(t, c, c2)
+
+	// original text is kept
+	in := "Package: pkg\nVersion: 1.0\nArchitecture: amd64\nInstalled-Size: 10\nDepends: libc6 (>=2.17)|libc6.1 ,\n zlib1g\nBreaks: old(<< 1.0)\n"
+
+	c = Control{}
+
+	_, err = c.ReadFrom(strings.NewReader(in))
+	require.NoError(t, err)
+
+	var b strings.Builder
+
+	_, err = c.WriteTo(&b)
+	require.NoError(t, err)
+	assert.Equal(t, in, b.String())
+
+	// changed value is formatted
+	c.Breaks = append(c.Breaks, Alternatives{{Name: "other"}})
+
+	b.Reset()
+
+	_, err = c.WriteTo(&b)
+	require.NoError(t, err)
+	assert.Contains(t, b.String(), "\nBreaks: old (<< 1.0), other\n")
+	assert.Contains(t, b.String(), "\nDepends: libc6 (>=2.17)|libc6.1 ,\n zlib1g\n")
+}
+
+func TestRelationMatch(t *testing.T) {
+	for _, tc := range []struct {
+		rel  string
+		name string
+		ver  string
+		res  bool
+	}{
+		{"a", "a", "1.0", true},
+		{"a", "b", "1.0", false},
+		{"a (>= 1.0)", "a", "1.0", true},
+		{"a (>= 1.0)", "a", "1.0~rc1", false},
+		{"a (>> 1.0)", "a", "1.0", false},
+		{"a (>> 1.0)", "a", "1.0+b1", true},
+		{"a (<< 2)", "a", "1:1", false},
+		{"a (<= 2)", "a", "2", true},
+		{"a (<= 2)", "a", "2.0", false},
+		{"a (= 1.0-1)", "a", "1.0-1", true},
+		{"a (= 1.0-1)", "a", "1.0-2", false},
+	} {
+		r, err := ParseRelation(tc.rel)
+		require.NoError(t, err)
+
+		assert.Equal(t, tc.res, r.Match(tc.name, MustParseVersion(tc.ver)), "%v  %v %v", tc.rel, tc.name, tc.ver)
+	}
+
+	r, err := ParseRelation("a [amd64 arm64]")
+	require.NoError(t, err)
+
+	assert.True(t, r.AppliesTo("amd64"))
+	assert.False(t, r.AppliesTo("i386"))
+
+	r, err = ParseRelation("a [!i386]")
+	require.NoError(t, err)
+
+	assert.True(t, r.AppliesTo("amd64"))
+	assert.False(t, r.AppliesTo("i386"))
+}
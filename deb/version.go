@@ -0,0 +1,215 @@
+package deb
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/nikandfor/errors"
+)
+
+type (
+	// Version is a Debian package version: [epoch:]upstream[-revision].
+	Version struct {
+		Epoch    int
+		Upstream string
+		Revision string
+	}
+)
+
+// ParseVersion parses version the way dpkg does.
+// Like dpkg it tolerates upstream not starting with a digit and unusual characters,
+// only structural errors are reported.
+func ParseVersion(orig string) (v Version, err error) {
+	s := strings.TrimSpace(orig)
+
+	if s == "" {
+		return v, errors.New("empty version")
+	}
+
+	if strings.ContainsAny(s, " \t\n\r") {
+		return v, errors.New("version has embedded spaces: %q", orig)
+	}
+
+	if p := strings.IndexByte(s, ':'); p != -1 {
+		if p == 0 {
+			return v, errors.New("epoch is empty: %q", orig)
+		}
+
+		v.Epoch, err = strconv.Atoi(s[:p])
+		if err != nil || v.Epoch < 0 {
+			return v, errors.New("bad epoch: %q", orig)
+		}
+
+		s = s[p+1:]
+
+		if s == "" {
+			return v, errors.New("nothing after colon: %q", orig)
+		}
+	}
+
+	if p := strings.LastIndexByte(s, '-'); p != -1 {
+		v.Revision = s[p+1:]
+		s = s[:p]
+
+		if v.Revision == "" {
+			return v, errors.New("revision is empty")
+		}
+	}
+
+	if s == "" {
+		return v, errors.New("upstream version is empty")
+	}
+
+	v.Upstream = s
+
+	return v, nil
+}
+
+// MustParseVersion is ParseVersion which panics on error.
+func MustParseVersion(s string) Version {
+	v, err := ParseVersion(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return v
+}
+
+func (v Version) String() string {
+	var b strings.Builder
+
+	if v.Epoch != 0 {
+		b.WriteString(strconv.Itoa(v.Epoch))
+		b.WriteByte(':')
+	}
+
+	b.WriteString(v.Upstream)
+
+	if v.Revision != "" {
+		b.WriteByte('-')
+		b.WriteString(v.Revision)
+	}
+
+	return b.String()
+}
+
+// Compare returns -1, 0 or 1 if v is less, equal or greater than w in dpkg ordering.
+func (v Version) Compare(w Version) int {
+	switch {
+	case v.Epoch < w.Epoch:
+		return -1
+	case v.Epoch > w.Epoch:
+		return 1
+	}
+
+	if r := verrevcmp(v.Upstream, w.Upstream); r != 0 {
+		return r
+	}
+
+	return verrevcmp(v.Revision, w.Revision)
+}
+
+func (v Version) Less(w Version) bool {
+	return v.Compare(w) < 0
+}
+
+// CompareVersions parses and compares a and b.
+func CompareVersions(a, b string) (int, error) {
+	va, err := ParseVersion(a)
+	if err != nil {
+		return 0, errors.Wrap(err, "parse %q", a)
+	}
+
+	vb, err := ParseVersion(b)
+	if err != nil {
+		return 0, errors.Wrap(err, "parse %q", b)
+	}
+
+	return va.Compare(vb), nil
+}
+
+// verrevcmp is dpkg lib/dpkg/version.c verrevcmp.
+func verrevcmp(a, b string) int {
+	i, j := 0, 0
+
+	for i < len(a) || j < len(b) {
+		firstDiff := 0
+
+		for i < len(a) && !isDigit(a[i]) || j < len(b) && !isDigit(b[j]) {
+			ac := order(a, i)
+			bc := order(b, j)
+
+			if ac != bc {
+				return sign(ac - bc)
+			}
+
+			i++
+			j++
+		}
+
+		for i < len(a) && a[i] == '0' {
+			i++
+		}
+
+		for j < len(b) && b[j] == '0' {
+			j++
+		}
+
+		for i < len(a) && isDigit(a[i]) && j < len(b) && isDigit(b[j]) {
+			if firstDiff == 0 {
+				firstDiff = int(a[i]) - int(b[j])
+			}
+
+			i++
+			j++
+		}
+
+		if i < len(a) && isDigit(a[i]) {
+			return 1
+		}
+
+		if j < len(b) && isDigit(b[j]) {
+			return -1
+		}
+
+		if firstDiff != 0 {
+			return sign(firstDiff)
+		}
+	}
+
+	return 0
+}
+
+func order(s string, i int) int {
+	if i >= len(s) {
+		return 0
+	}
+
+	c := s[i]
+
+	switch {
+	case isDigit(c):
+		return 0
+	case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		return int(c)
+	case c == '~':
+		return -1
+	default:
+		return int(c) + 256
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func sign(x int) int {
+	switch {
+	case x < 0:
+		return -1
+	case x > 0:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,127 @@
+package deb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareVersions(t *testing.T) {
+	// expected results are from dpkg --compare-versions
+	for _, tc := range []struct {
+		a, b string
+		res  int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "1.1", -1},
+		{"1.1", "1.0", 1},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~rc1", "1.0~rc2", -1},
+		{"1.0~~", "1.0~", -1},
+		{"1.0~~a", "1.0~~", 1},
+		{"1.0~", "1.0", -1},
+		{"1.0", "1.0+b1", -1},
+		{"1.0+b1", "1.0+b2", -1},
+		{"1.0a", "1.0", 1},
+		{"1.0", "1.0.", -1},
+		{"1.0", "1.0-0", 0},
+		{"1.0-0", "1.0", 0},
+		{"1.0-1", "1.0-2", -1},
+		{"1.0-1", "1.0-1ubuntu1", -1},
+		{"1.0-1ubuntu1", "1.0-1ubuntu2", -1},
+		{"2.30-0ubuntu1", "2.30-0ubuntu1.1", -1},
+		{"1:0.1", "2.0", 1},
+		{"0:1.0", "1.0", 0},
+		{"1:1.0", "2:0.1", -1},
+		{"10:1.0", "9:2.0", 1},
+		{"1.2.3", "1.2.10", -1},
+		{"1.002", "1.2", 0},
+		{"1.0.0", "1.0", 1},
+		{"1.0+dfsg-1", "1.0-1", 1},
+		{"1.0-1~bpo1", "1.0-1", -1},
+		{"1.0-1+deb10u1", "1.0-1", 1},
+		{"1.0-1+deb10u1", "1.0-1+deb10u2", -1},
+		{"7.6p2-4", "7.6-0", 1},
+		{"1.0.3-3", "1.0-1", 1},
+		{"1.3", "1.2.2-2", 1},
+		{"1.3", "1.2.2", 1},
+		{"0-pre", "0-pre", 0},
+		{"0-pre", "0-pree", -1},
+		{"1.1.6r2-2", "1.1.6r-1", 1},
+		{"2.6b2-1", "2.6b-2", 1},
+		{"98.1p5-1", "98.1-pre2-b6-2", -1},
+		{"0.4a6-2", "0.4-1", 1},
+		{"1:3.0.5-2", "1:3.0.5.1", -1},
+		{"10.3", "10.2", 1},
+		{"10.10", "10.9", 1},
+		{"2.0", "2.0a", -1},
+		{"2.0a", "2.0b", -1},
+		{"2.0A", "2.0a", -1},
+		{"2.0+", "2.0.", -1},
+		{"1.0~beta1~svn1245", "1.0~beta1", -1},
+		{"1.0~beta1", "1.0~beta1~svn1245", 1},
+		{"1.0~alpha", "1.0~beta", -1},
+		{"1.0~beta", "1.0~rc", -1},
+		{"1.0~rc", "1.0", -1},
+		{"1.0", "1.0+git20200101", -1},
+		{"1.0+git20200101", "1.0.1", -1},
+		{"1.0.1", "1.0.1+really1.0", -1},
+		{"5.0", "5.0-0", 0},
+		{"5.0-0", "5.0-0.1", -1},
+		{"0", "0.0", -1},
+		{"000", "0", 0},
+		{"1", "01", 0},
+		{"a", "b", -1},
+		{"a", "1", 1},
+		{"1.2-3-4", "1.2-3-5", -1},
+		{"1.2-3-4", "1.2.3-4", -1},
+		{"2:1.0~rc1", "1:9.9", 1},
+		{"1.0++", "1.0+", 1},
+		{"1.0+~", "1.0+", -1},
+		{"1.0~+", "1.0~", 1},
+		{"3.14", "3.14159", -1},
+		{"3.14159", "3.141", 1},
+		{"1.0.0~rc1-1", "1.0.0-1", -1},
+		{"20201010", "20201009", 1},
+		{"1.0-1.1", "1.0-1.01", 0},
+		{"1.0-1a", "1.0-1", 1},
+		{"4.19.0-12", "4.19.0-13", -1},
+		{"4.19.0-13-amd64", "4.19.0-13", 1},
+	} {
+		res, err := CompareVersions(tc.a, tc.b)
+		require.NoError(t, err, "%v %v", tc.a, tc.b)
+		assert.Equal(t, tc.res, res, "%v %v", tc.a, tc.b)
+
+		res, err = CompareVersions(tc.b, tc.a)
+		require.NoError(t, err, "%v %v", tc.b, tc.a)
+		assert.Equal(t, -tc.res, res, "%v %v", tc.b, tc.a)
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	for _, tc := range []struct {
+		s string
+		v Version
+	}{
+		{"1.0", Version{Upstream: "1.0"}},
+		{"1.0-1", Version{Upstream: "1.0", Revision: "1"}},
+		{"2:1.0-1ubuntu1", Version{Epoch: 2, Upstream: "1.0", Revision: "1ubuntu1"}},
+		{"1.2-3-4", Version{Upstream: "1.2-3", Revision: "4"}},
+		{"0:1.0~rc1+dfsg", Version{Upstream: "1.0~rc1+dfsg"}},
+		{" 1.0 ", Version{Upstream: "1.0"}},
+	} {
+		v, err := ParseVersion(tc.s)
+		if assert.NoError(t, err, tc.s) {
+			assert.Equal(t, tc.v, v, tc.s)
+		}
+	}
+
+	assert.Equal(t, "2:1.0-1ubuntu1", MustParseVersion("2:1.0-1ubuntu1").String())
+	assert.Equal(t, "1.0", MustParseVersion("0:1.0").String())
+
+	for _, s := range []string{"", "1.0 1", ":1.0", "a:1.0", "-1:1.0", "1:", "1.0-", "-1"} {
+		_, err := ParseVersion(s)
+		assert.Error(t, err, "%q", s)
+	}
+}
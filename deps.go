@@ -0,0 +1,238 @@
+package limbo
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/ulikunitz/xz"
+
+	"github.com/rndcenter/limbo/deb"
+)
+
+type (
+	// DepsReport lists unsatisfiable dependencies for a suite and architecture.
+	DepsReport struct {
+		Suite  string
+		Arch   string
+		Broken []*BrokenDep
+	}
+
+	// BrokenDep is a dependency no known package satisfies.
+	BrokenDep struct {
+		Package      string
+		Version      string
+		Architecture string
+		Filename     string
+
+		Field    string
+		Relation string
+	}
+
+	// depsUniverse is packages and virtual packages available on some arch.
+	depsUniverse map[string][]provider
+
+	provider struct {
+		Version   deb.Version
+		Versioned bool
+	}
+)
+
+// CheckDeps reports unsatisfiable Depends and Pre-Depends of the repository packages
+// per suite and architecture. Dependencies are looked up in the same suite.
+// External packages are assumed to be installable along with the repository ones.
+// Changes made by other processes are taken into account.
+func (l *Limbo) CheckDeps(external []*Package) (reps []*DepsReport, err error) {
+	err = l.lock()
+	if err != nil {
+		return nil, err
+	}
+
+	defer l.release()
+
+	for _, s := range l.suiteNames() {
+		reps = append(reps, l.checkSuiteDeps(s, external)...)
+	}
+
+	return reps, nil
+}
+
+// checkSuiteDeps checks suite deps. l.mu must be held.
+func (l *Limbo) checkSuiteDeps(suite string, external []*Package) (reps []*DepsReport) {
+	pkgs := l.suitePackages(suite, "")
+
+	sortPackages(pkgs)
+
+	for _, a := range releaseArchs(l.archs(suite)) {
+		u := depsUniverse{}
+
+		u.add(a, pkgs)
+		u.add(a, external)
+
+		rep := &DepsReport{
+			Suite: suite,
+			Arch:  a,
+		}
+
+		for _, p := range pkgs {
+			if pa := p.Control.Architecture; pa != a && pa != ArchAll {
+				continue
+			}
+
+			rep.Broken = append(rep.Broken, u.check(a, p, "Pre-Depends", p.Control.PreDepends)...)
+			rep.Broken = append(rep.Broken, u.check(a, p, "Depends", p.Control.Depends)...)
+		}
+
+		reps = append(reps, rep)
+	}
+
+	return reps
+}
+
+func (u depsUniverse) add(arch string, pkgs []*Package) {
+	for _, p := range pkgs {
+		if pa := p.Control.Architecture; pa != arch && pa != ArchAll {
+			continue
+		}
+
+		v, err := deb.ParseVersion(p.Control.Version)
+		if err == nil {
+			u[p.Control.Package] = append(u[p.Control.Package], provider{Version: v, Versioned: true})
+		}
+
+		for _, alt := range p.Control.Provides {
+			for _, r := range alt {
+				pr := provider{}
+
+				if r.Op == deb.OpEq {
+					pr.Version, err = deb.ParseVersion(r.Version)
+					pr.Versioned = err == nil
+				}
+
+				u[r.Name] = append(u[r.Name], pr)
+			}
+		}
+	}
+}
+
+func (u depsUniverse) check(arch string, p *Package, field string, rs deb.Relations) (broken []*BrokenDep) {
+	for _, alt := range rs {
+		if u.satisfied(arch, alt) {
+			continue
+		}
+
+		broken = append(broken, &BrokenDep{
+			Package:      p.Control.Package,
+			Version:      p.Control.Version,
+			Architecture: p.Control.Architecture,
+			Filename:     p.Filename,
+			Field:        field,
+			Relation:     alt.String(),
+		})
+	}
+
+	return broken
+}
+
+func (u depsUniverse) satisfied(arch string, alt deb.Alternatives) bool {
+	applies := false
+
+	for _, r := range alt {
+		if !r.AppliesTo(arch) {
+			continue
+		}
+
+		applies = true
+
+		for _, pr := range u[r.Name] {
+			if r.Op == "" {
+				return true
+			}
+
+			if pr.Versioned && r.Match(r.Name, pr.Version) {
+				return true
+			}
+		}
+	}
+
+	return !applies
+}
+
+// indexClient is used by LoadPackagesIndex, so a stalled server can't hang the caller.
+var indexClient = &http.Client{Timeout: 2 * time.Minute}
+
+// LoadPackagesIndex reads Packages index from a local file or http(s) url.
+// .gz and .xz files are decompressed.
+func LoadPackagesIndex(loc string) (pkgs []*Package, err error) {
+	var rc io.ReadCloser
+
+	if strings.HasPrefix(loc, "http://") || strings.HasPrefix(loc, "https://") {
+		resp, err := indexClient.Get(loc)
+		if err != nil {
+			return nil, errors.Wrap(err, "get")
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			_ = resp.Body.Close()
+
+			return nil, errors.Errorf("get: %v", resp.Status)
+		}
+
+		rc = resp.Body
+	} else {
+		rc, err = os.Open(loc)
+		if err != nil {
+			return nil, errors.Wrap(err, "open")
+		}
+	}
+
+	defer func() {
+		e := rc.Close()
+		if err == nil {
+			err = errors.Wrap(e, "close")
+		}
+	}()
+
+	r, err := decompress(rc, path.Base(loc))
+	if err != nil {
+		return nil, err
+	}
+
+	return ReadPackages(r)
+}
+
+// decompress wraps r with decompressor by file name extension.
+func decompress(r io.Reader, name string) (io.Reader, error) {
+	switch path.Ext(name) {
+	case ".gz":
+		g, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "gzip")
+		}
+
+		return g, nil
+	case ".xz":
+		x, err := xz.NewReader(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "xz")
+		}
+
+		return x, nil
+	default:
+		return r, nil
+	}
+}
+
+// BrokenCount returns the number of broken dependencies in all the reports.
+func BrokenCount(reps []*DepsReport) (n int) {
+	for _, r := range reps {
+		n += len(r.Broken)
+	}
+
+	return n
+}
@@ -0,0 +1,108 @@
+package limbo
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rndcenter/limbo/deb"
+)
+
+func TestCheckDeps(t *testing.T) {
+	root, err := ioutil.TempDir("", "limbo_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	l, err := New(context.Background(), root)
+	require.NoError(t, err)
+
+	saveDepsPackage(t, l.Pool, "app", "1.0", "amd64", "libfoo (>= 2), mail-transport-agent, libc6")
+	saveDepsPackage(t, l.Pool, "libfoo", "1.5", "amd64", "")
+	saveDepsPackage(t, l.Pool, "postfix", "3.0", "amd64", "")
+
+	err = l.Load()
+	require.NoError(t, err)
+
+	reps, err := l.CheckDeps(nil)
+	require.NoError(t, err)
+	require.Len(t, reps, 1)
+
+	assert.Equal(t, "amd64", reps[0].Arch)
+
+	var rels []string
+	for _, b := range reps[0].Broken {
+		assert.Equal(t, "app", b.Package)
+		assert.Equal(t, "Depends", b.Field)
+
+		rels = append(rels, b.Relation)
+	}
+
+	assert.Equal(t, []string{"libfoo (>= 2)", "mail-transport-agent", "libc6"}, rels)
+
+	ext, err := ReadPackages(strings.NewReader(`Package: libc6
+Version: 2.31-13
+Architecture: amd64
+Filename: pool/main/g/glibc/libc6_2.31-13_amd64.deb
+Size: 2823
+
+Package: exim4
+Version: 4.94-1
+Architecture: amd64
+Provides: mail-transport-agent
+`))
+	require.NoError(t, err)
+	require.Len(t, ext, 2)
+
+	assert.Equal(t, "pool/main/g/glibc/libc6_2.31-13_amd64.deb", ext[0].Filename)
+	assert.Equal(t, int64(2823), ext[0].Size)
+	assert.Nil(t, ext[0].Control.Rest)
+
+	reps, err = l.CheckDeps(ext)
+	require.NoError(t, err)
+
+	if assert.Equal(t, 1, BrokenCount(reps)) {
+		assert.Equal(t, "libfoo (>= 2)", reps[0].Broken[0].Relation)
+	}
+
+	// another process adds the missing version
+	saveDepsPackage(t, l.Pool, "libfoo", "2.0", "amd64", "")
+
+	o, err := New(context.Background(), root)
+	require.NoError(t, err)
+
+	err = o.Load()
+	require.NoError(t, err)
+
+	reps, err = l.CheckDeps(ext)
+	require.NoError(t, err)
+	assert.Equal(t, 0, BrokenCount(reps))
+}
+
+func saveDepsPackage(t testing.TB, dir, name, ver, arch, depends string) {
+	t.Helper()
+
+	rs, err := deb.ParseRelations(depends)
+	require.NoError(t, err)
+
+	p := deb.New(context.Background())
+
+	p.Control = deb.Control{
+		Package:      name,
+		Version:      ver,
+		Architecture: arch,
+		Depends:      rs,
+		Description:  "test package",
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	require.NoError(t, err)
+
+	err = p.Save(filepath.Join(dir, p.CanonicalName()))
+	require.NoError(t, err)
+}
@@ -0,0 +1,359 @@
+package limbo
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/ulikunitz/xz"
+
+	"github.com/rndcenter/limbo/deb"
+	"github.com/rndcenter/limbo/textproto"
+)
+
+type (
+	Package struct {
+		Filename string
+		Size     int64
+
+		MD5Sum    string
+		SHA1Sum   string `json:"SHA1"`
+		SHA256Sum string `json:"SHA256"`
+
+		Control deb.Control
+	}
+)
+
+const ArchAll = "all"
+
+func (l *Limbo) readPoolFile(path string) (_ *Package, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "open file")
+	}
+	defer func() {
+		e := f.Close()
+		if err == nil {
+			err = errors.Wrap(e, "close file")
+		}
+	}()
+
+	p := deb.New(l.ctx)
+	p.MetaOnly = true
+
+	n, err := p.ReadFrom(f)
+	if err != nil {
+		return nil, errors.Wrap(err, "read package")
+	}
+
+	rel, err := filepath.Rel(l.Path, path)
+	if err != nil {
+		return nil, errors.Wrap(err, "relative path")
+	}
+
+	return &Package{
+		Filename:  filepath.ToSlash(rel),
+		Size:      n,
+		MD5Sum:    hex.EncodeToString(p.MD5Sum[:]),
+		SHA1Sum:   hex.EncodeToString(p.SHA1Sum[:]),
+		SHA256Sum: hex.EncodeToString(p.SHA256Sum[:]),
+		Control:   p.Control,
+	}, nil
+}
+
+// ReadPackages parses Packages index.
+func ReadPackages(r io.Reader) (pkgs []*Package, err error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64<<10), 16<<20)
+
+	var b bytes.Buffer
+
+	flush := func() error {
+		if b.Len() == 0 {
+			return nil
+		}
+
+		p, err := parsePackageStanza(b.Bytes())
+		if err != nil {
+			return err
+		}
+
+		b.Reset()
+
+		pkgs = append(pkgs, p)
+
+		return nil
+	}
+
+	for s.Scan() {
+		l := s.Bytes()
+
+		if len(bytes.TrimSpace(l)) == 0 {
+			err = flush()
+			if err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		_, _ = b.Write(l)
+		_ = b.WriteByte('\n')
+	}
+
+	err = s.Err()
+	if err != nil {
+		return nil, errors.Wrap(err, "read")
+	}
+
+	err = flush()
+	if err != nil {
+		return nil, err
+	}
+
+	return pkgs, nil
+}
+
+func parsePackageStanza(data []byte) (p *Package, err error) {
+	p = &Package{}
+
+	_, err = p.Control.ReadFrom(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrap(err, "parse stanza")
+	}
+
+	take := func(k string) string {
+		v, _ := p.Control.Rest[k].(string)
+
+		delete(p.Control.Rest, k)
+
+		for i, n := range p.Control.RestOrder {
+			if n == k {
+				p.Control.RestOrder = append(p.Control.RestOrder[:i], p.Control.RestOrder[i+1:]...)
+				break
+			}
+		}
+
+		return v
+	}
+
+	p.Filename = take("Filename")
+	p.MD5Sum = take("MD5sum")
+	p.SHA1Sum = take("SHA1")
+	p.SHA256Sum = take("SHA256")
+
+	if s := take("Size"); s != "" {
+		p.Size, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse size of %v", p.Control.Package)
+		}
+	}
+
+	if len(p.Control.Rest) == 0 {
+		p.Control.Rest = nil
+		p.Control.RestOrder = nil
+	}
+
+	return p, nil
+}
+
+func (p *Package) WriteTo(w io.Writer) (n int64, err error) {
+	n, err = p.Control.WriteTo(w)
+	if err != nil {
+		return n, errors.Wrap(err, "write control")
+	}
+
+	cw := &countWriter{w: w}
+	tw := textproto.NewWriter(cw)
+
+	for _, kv := range [][2]string{
+		{"Filename", p.Filename},
+		{"Size", strconv.FormatInt(p.Size, 10)},
+		{"MD5sum", p.MD5Sum},
+		{"SHA1", p.SHA1Sum},
+		{"SHA256", p.SHA256Sum},
+	} {
+		err = tw.PairStrings(kv[0], kv[1])
+		if err != nil {
+			return n + cw.n, errors.Wrapf(err, "write %v", kv[0])
+		}
+	}
+
+	return n + cw.n, nil
+}
+
+// archs returns architectures of the suite packages. ArchAll is always included.
+func (l *Limbo) archs(suite string) []string {
+	return l.distArchs(l.suites[suite])
+}
+
+func (l *Limbo) distArchs(d dist) (archs []string) {
+	set := map[string]struct{}{ArchAll: {}}
+
+	for _, p := range l.distPackages(d, "") {
+		set[p.Control.Architecture] = struct{}{}
+	}
+
+	for a := range set {
+		archs = append(archs, a)
+	}
+
+	sort.Strings(archs)
+
+	return archs
+}
+
+// affectedArchs returns suite indexes a package of arch a is listed in.
+func (l *Limbo) affectedArchs(suite, a string) []string {
+	if a == ArchAll {
+		return l.archs(suite)
+	}
+
+	return []string{a}
+}
+
+// writeIndexes writes <dir>/<component>/binary-<arch>/Packages{,.gz,.xz} of dist d.
+// Packages of architecture "all" are also listed in every binary-<arch> index.
+func (l *Limbo) writeIndexes(dir string, d dist, comps, archs []string) (err error) {
+	for _, c := range comps {
+		pkgs := l.distPackages(d, c)
+
+		for _, a := range archs {
+			var list []*Package
+
+			for _, p := range pkgs {
+				if pa := p.Control.Architecture; pa == a || pa == ArchAll {
+					list = append(list, p)
+				}
+			}
+
+			sortPackages(list)
+
+			err = l.writePackages(filepath.Join(dir, c, "binary-"+a), list)
+			if err != nil {
+				return errors.Wrapf(err, "write %v/%v index", c, a)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (l *Limbo) writePackages(dir string, pkgs []*Package) (err error) {
+	var b bytes.Buffer
+
+	for i, p := range pkgs {
+		if i != 0 {
+			_ = b.WriteByte('\n')
+		}
+
+		_, err = p.WriteTo(&b)
+		if err != nil {
+			return errors.Wrapf(err, "package %v", p.Filename)
+		}
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return errors.Wrap(err, "create dir")
+	}
+
+	l.tr.Printw("write index", "dir", dir, "packages", len(pkgs))
+
+	return writeCompressed(filepath.Join(dir, "Packages"), b.Bytes())
+}
+
+// writeCompressed writes data to base, base.gz and base.xz.
+func writeCompressed(base string, data []byte) (err error) {
+	err = writeFileAtomic(base, data, 0644)
+	if err != nil {
+		return errors.Wrap(err, "write plain")
+	}
+
+	var b bytes.Buffer
+
+	g := gzip.NewWriter(&b)
+
+	_, err = g.Write(data)
+	if err != nil {
+		return errors.Wrap(err, "gzip")
+	}
+
+	err = g.Close()
+	if err != nil {
+		return errors.Wrap(err, "gzip")
+	}
+
+	err = writeFileAtomic(base+".gz", b.Bytes(), 0644)
+	if err != nil {
+		return errors.Wrap(err, "write gz")
+	}
+
+	b.Reset()
+
+	x, err := xz.NewWriter(&b)
+	if err != nil {
+		return errors.Wrap(err, "xz")
+	}
+
+	_, err = x.Write(data)
+	if err != nil {
+		return errors.Wrap(err, "xz")
+	}
+
+	err = x.Close()
+	if err != nil {
+		return errors.Wrap(err, "xz")
+	}
+
+	err = writeFileAtomic(base+".xz", b.Bytes(), 0644)
+	if err != nil {
+		return errors.Wrap(err, "write xz")
+	}
+
+	return nil
+}
+
+func sortPackages(pkgs []*Package) {
+	sort.Slice(pkgs, func(i, j int) bool {
+		return packageLess(pkgs[i], pkgs[j])
+	})
+}
+
+// packageLess orders packages by name, version and architecture.
+func packageLess(x, y *Package) bool {
+	a, b := &x.Control, &y.Control
+
+	if a.Package != b.Package {
+		return a.Package < b.Package
+	}
+
+	if a.Version != b.Version {
+		if r, err := deb.CompareVersions(a.Version, b.Version); err == nil && r != 0 {
+			return r < 0
+		}
+
+		return a.Version < b.Version
+	}
+
+	return a.Architecture < b.Architecture
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (n int, err error) {
+	n, err = w.w.Write(p)
+	w.n += int64(n)
+
+	return
+}
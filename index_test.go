@@ -0,0 +1,121 @@
+package limbo
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rndcenter/limbo/deb"
+)
+
+func TestUpdateIndex(t *testing.T) {
+	root, err := ioutil.TempDir("", "limbo_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	l, err := New(context.Background(), root)
+	require.NoError(t, err)
+
+	savePackage(t, l.Pool, "pkg", "1.0", "amd64")
+	savePackage(t, l.Pool, "doc", "0.1", "all")
+
+	err = l.UpdateIndex()
+	require.NoError(t, err)
+
+	data, err := ioutil.ReadFile(filepath.Join(l.Dists, "stable", "main", "binary-amd64", "Packages"))
+	require.NoError(t, err)
+
+	stanzas := strings.Split(strings.TrimSpace(string(data)), "\n\n")
+	if assert.Len(t, stanzas, 2) {
+		assert.Contains(t, stanzas[0], "Package: doc\n")
+		assert.Contains(t, stanzas[0], "Filename: pool/doc_0.1_all.deb\n")
+		assert.Contains(t, stanzas[1], "Package: pkg\n")
+		assert.Contains(t, stanzas[1], "Filename: pool/pkg_1.0_amd64.deb\n")
+		assert.Contains(t, stanzas[1], "SHA256: ")
+	}
+
+	for _, ext := range []string{".gz", ".xz"} {
+		_, err = os.Stat(filepath.Join(l.Dists, "stable", "main", "binary-amd64", "Packages"+ext))
+		assert.NoError(t, err, ext)
+	}
+
+	data, err = ioutil.ReadFile(filepath.Join(l.Dists, "stable", "Release"))
+	require.NoError(t, err)
+
+	rel := string(data)
+
+	assert.Contains(t, rel, "Suite: stable\n")
+	assert.Contains(t, rel, "Architectures: amd64\n")
+	assert.Contains(t, rel, "Components: main\n")
+	assert.Contains(t, rel, "\nSHA256:\n")
+	assert.Contains(t, rel, " main/binary-amd64/Packages.xz\n")
+	assert.NotContains(t, rel, " Release\n")
+}
+
+func savePackage(t testing.TB, dir, name, ver, arch string) *deb.Package {
+	t.Helper()
+
+	p := deb.New(context.Background())
+
+	p.Control = deb.Control{
+		Package:      name,
+		Version:      ver,
+		Architecture: arch,
+		Description:  "test package",
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	require.NoError(t, err)
+
+	err = p.Save(filepath.Join(dir, p.CanonicalName()))
+	require.NoError(t, err)
+
+	return p
+}
+
+func TestUpdateIndexCache(t *testing.T) {
+	root, err := ioutil.TempDir("", "limbo_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	l, err := New(context.Background(), root)
+	require.NoError(t, err)
+
+	savePackage(t, l.Pool, "pkg", "1.0", "amd64")
+	savePackage(t, l.Pool, "old", "1.0", "amd64")
+
+	err = l.UpdateIndex()
+	require.NoError(t, err)
+
+	c, err := l.loadCache()
+	require.NoError(t, err)
+	require.Len(t, c, 2)
+
+	// cached entry is used while the file is unchanged
+	c["pool/pkg_1.0_amd64.deb"].Package.Control.Description = "from cache"
+
+	err = l.saveCache(c)
+	require.NoError(t, err)
+
+	err = os.Remove(filepath.Join(l.Pool, "old_1.0_amd64.deb"))
+	require.NoError(t, err)
+
+	err = l.UpdateIndex()
+	require.NoError(t, err)
+
+	data, err := ioutil.ReadFile(filepath.Join(l.Dists, "stable", "main", "binary-amd64", "Packages"))
+	require.NoError(t, err)
+
+	assert.Contains(t, string(data), "Description: from cache\n")
+	assert.NotContains(t, string(data), "Package: old\n")
+
+	c, err = l.loadCache()
+	require.NoError(t, err)
+	assert.Len(t, c, 1)
+}
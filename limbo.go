@@ -4,31 +4,71 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/nikandfor/tlog"
 	"github.com/pkg/errors"
-	"github.com/rndcenter/limbo/deb"
 )
 
 type (
 	Limbo struct {
-		Path string
-		Pool string
-
-		ctx context.Context
-		tr  tlog.Span
+		Path      string
+		Pool      string
+		Dists     string
+		DB        string
+		Snapshots string
+
+		// Generations holds published dists trees, dists/<suite> links to the current one.
+		Generations string
+
+		// Suite and Component are defaults for uploads and unassigned pool files.
+		Suite     string
+		Component string
+
+		Release   Release
+		Signer    *Signer
+		Retention []RetentionRule
+
+		ctx     context.Context
+		tr      tlog.Span
+		metrics *metrics
+
+		mu      sync.Mutex
+		pkgs    map[string]*Package // Filename -> Package
+		cache   poolCache
+		suites  map[string]dist
+		orphans fileSet
+		closed  bool
+
+		lockf   *os.File
+		lockGen int64 // repo generation read when locked
+		gen     int64 // repo generation the state is loaded at
 	}
 )
 
+// ErrClosed is returned by operations writing the repo after Close.
+var ErrClosed = errors.New("limbo is closed")
+
 func New(ctx context.Context, p string) (*Limbo, error) {
 	tr := tlog.SpawnOrStartFromContext(ctx, "limbo")
 
 	l := &Limbo{
-		Path: p,
-		Pool: filepath.Join(p, "pool"),
+		Path:      p,
+		Pool:      filepath.Join(p, "pool"),
+		Dists:     filepath.Join(p, "dists"),
+		DB:        filepath.Join(p, "db"),
+		Snapshots: filepath.Join(p, "snapshots"),
+
+		Generations: filepath.Join(p, "generations"),
+
+		Suite:     "stable",
+		Component: "main",
 
-		ctx: context.Background(),
-		tr:  tr,
+		ctx:     context.Background(),
+		tr:      tr,
+		metrics: newMetrics(),
+
+		gen: -1,
 	}
 
 	if tr.Logger != nil {
@@ -38,7 +78,67 @@ func New(ctx context.Context, p string) (*Limbo, error) {
 	return l, nil
 }
 
+// Close waits for the index and database writes in progress to finish.
+// Operations changing the repo started after Close fail with ErrClosed before changing anything.
+// Every file is written to a temp file and renamed, so the repo is consistent after Close.
+func (l *Limbo) Close() error {
+	defer l.mu.Unlock()
+	l.mu.Lock()
+
+	l.closed = true
+
+	l.tr.Printw("closed")
+
+	if l.lockf == nil {
+		return nil
+	}
+
+	return l.lockf.Close()
+}
+
+// UpdateIndex reads the pool and regenerates all the indexes.
 func (l *Limbo) UpdateIndex() (err error) {
+	err = l.lockRepo()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		l.unlock(err)
+	}()
+
+	err = l.load()
+	if err != nil {
+		return err
+	}
+
+	for _, s := range l.suiteNames() {
+		err = l.publish(s, l.suites[s].components(), l.archs(s))
+		if err != nil {
+			return errors.Wrapf(err, "publish %v", s)
+		}
+	}
+
+	return nil
+}
+
+// Load reads the pool and suites references.
+// Only new and changed files are parsed, the rest is taken from the cache.
+func (l *Limbo) Load() (err error) {
+	err = l.lockRepo()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		l.unlock(err)
+	}()
+
+	return l.load()
+}
+
+// load is Load with the locks held.
+func (l *Limbo) load() (err error) {
 	err = os.MkdirAll(l.Pool, 0755)
 	if err != nil {
 		return errors.Wrap(err, "create pool dir")
@@ -46,6 +146,17 @@ func (l *Limbo) UpdateIndex() (err error) {
 
 	l.tr.Printw("read pool")
 
+	cache, err := l.loadCache()
+	if err != nil {
+		l.tr.Printw("load pool cache", "err", err)
+
+		cache = poolCache{}
+	}
+
+	pkgs := make(map[string]*Package)
+	next := poolCache{}
+	parsed := 0
+
 	err = filepath.Walk(l.Pool, func(path string, inf os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -63,13 +174,25 @@ func (l *Limbo) UpdateIndex() (err error) {
 		//	tr := l.tr.Spawn("pool_file")
 		//	ctx := tlog.ContextWithSpan(context.Background(), tr)
 
-		p, err := deb.Open(l.ctx, path)
+		rel, err := filepath.Rel(l.Path, path)
 		if err != nil {
-			l.tr.Printw("index pkg pool", "path", path, "err", tlog.FormatNext("%+v"), err)
-			return nil
+			return err
+		}
+
+		p := cache.lookup(filepath.ToSlash(rel), inf)
+
+		if p == nil {
+			p, err = l.readPoolFile(path)
+			if err != nil {
+				l.tr.Printw("index pkg pool", "path", path, "err", tlog.FormatNext("%+v"), err)
+				return nil
+			}
+
+			parsed++
 		}
 
-		_ = p
+		pkgs[p.Filename] = p
+		next.add(p, inf)
 
 		return nil
 	})
@@ -77,5 +200,30 @@ func (l *Limbo) UpdateIndex() (err error) {
 		return errors.Wrap(err, "read pool")
 	}
 
+	l.tr.Printw("pool read", "files", len(pkgs), "parsed", parsed)
+
+	suites, orphans, err := l.loadSuites()
+	if err != nil {
+		return errors.Wrap(err, "load suites")
+	}
+
+	l.pkgs = pkgs
+	l.cache = next
+
+	err = l.saveCache(next)
+	if err != nil {
+		return errors.Wrap(err, "save pool cache")
+	}
+
+	l.assignSuites(suites, orphans)
+
+	err = l.saveSuites()
+	if err != nil {
+		return errors.Wrap(err, "save suites")
+	}
+
+	l.gen = l.lockGen
+	l.updateStats()
+
 	return nil
 }
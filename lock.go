@@ -0,0 +1,140 @@
+package limbo
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+func (l *Limbo) lockFile() string {
+	return filepath.Join(l.DB, "lock")
+}
+
+// lock takes l.mu and the repo lock shared with other processes:
+// the server, its restarted copy and command line tools.
+// The state is reloaded if another process changed the repo since we last read it.
+// It fails with ErrClosed after Close. l.unlock or l.release must be called if no error.
+func (l *Limbo) lock() (err error) {
+	err = l.lockRepo()
+	if err != nil {
+		return err
+	}
+
+	if l.gen == l.lockGen {
+		return nil
+	}
+
+	l.tr.Printw("repo changed, reload", "generation", l.lockGen)
+
+	err = l.load()
+	if err != nil {
+		l.unlock(err)
+		return errors.Wrap(err, "reload")
+	}
+
+	return nil
+}
+
+// lockRepo is lock without reload.
+func (l *Limbo) lockRepo() (err error) {
+	l.mu.Lock()
+	defer func() {
+		if err != nil {
+			l.mu.Unlock()
+		}
+	}()
+
+	if l.closed {
+		return ErrClosed
+	}
+
+	if l.lockf == nil {
+		err = os.MkdirAll(l.DB, 0755)
+		if err != nil {
+			return errors.Wrap(err, "create db dir")
+		}
+
+		l.lockf, err = os.OpenFile(l.lockFile(), os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return errors.Wrap(err, "open lock file")
+		}
+	}
+
+	err = flock(l.lockf)
+	if err != nil {
+		return errors.Wrap(err, "lock repo")
+	}
+
+	l.lockGen, err = readGen(l.lockf)
+	if err != nil {
+		_ = funlock(l.lockf)
+		return errors.Wrap(err, "read lock file")
+	}
+
+	return nil
+}
+
+// unlock bumps the repo generation, updates metrics and releases the locks.
+// If the operation failed the state may not match the disk, so it's reloaded next time.
+func (l *Limbo) unlock(err error) {
+	l.updateStats()
+
+	next := l.lockGen + 1
+
+	switch {
+	case err != nil:
+		l.gen = -1
+	case l.gen == l.lockGen:
+		l.gen = next
+	}
+
+	werr := writeGen(l.lockf, next)
+	if werr != nil {
+		l.tr.Printw("write lock file", "err", werr)
+	}
+
+	l.release()
+}
+
+// release releases the locks without bumping the repo generation.
+// It's used by operations which don't change the repo state.
+func (l *Limbo) release() {
+	err := funlock(l.lockf)
+	if err != nil {
+		l.tr.Printw("unlock repo", "err", err)
+	}
+
+	l.mu.Unlock()
+}
+
+// readGen reads repo generation from the lock file. Empty file is generation 0.
+func readGen(f *os.File) (int64, error) {
+	var buf [32]byte
+
+	n, err := f.ReadAt(buf[:], 0)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	s := strings.TrimSpace(string(buf[:n]))
+	if s == "" {
+		return 0, nil
+	}
+
+	return strconv.ParseInt(s, 10, 64)
+}
+
+func writeGen(f *os.File, gen int64) error {
+	err := f.Truncate(0)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.WriteAt([]byte(strconv.FormatInt(gen, 10)+"\n"), 0)
+
+	return err
+}
@@ -0,0 +1,115 @@
+package limbo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSharedRepo(t *testing.T) {
+	root, err := ioutil.TempDir("", "limbo_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	// a and b are like the server and its restarted copy
+	a, err := New(context.Background(), root)
+	require.NoError(t, err)
+
+	err = a.UpdateIndex()
+	require.NoError(t, err)
+
+	b, err := New(context.Background(), root)
+	require.NoError(t, err)
+
+	err = b.UpdateIndex()
+	require.NoError(t, err)
+
+	_, err = a.UploadTo(bytes.NewReader(packageBytes(t, "app", "1.0", "amd64")), "staging", "main")
+	require.NoError(t, err)
+
+	// b hasn't seen the upload but must find it
+	_, err = b.Promote("app", "1.0", "staging", "stable")
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+
+	for i, l := range []*Limbo{a, b, a, b} {
+		wg.Add(1)
+
+		go func(i int, l *Limbo) {
+			defer wg.Done()
+
+			_, err := l.Upload(bytes.NewReader(packageBytes(t, fmt.Sprintf("pkg%d", i), "1.0", "amd64")))
+			assert.NoError(t, err)
+		}(i, l)
+	}
+
+	wg.Wait()
+
+	// a must not drop b's promotion
+	_, err = a.Upload(bytes.NewReader(packageBytes(t, "app", "2.0", "amd64")))
+	require.NoError(t, err)
+
+	data, err := ioutil.ReadFile(filepath.Join(root, "dists", "stable", "main", "binary-amd64", "Packages"))
+	require.NoError(t, err)
+
+	for _, fn := range []string{"app_1.0", "app_2.0", "pkg0_1.0", "pkg1_1.0", "pkg2_1.0", "pkg3_1.0"} {
+		assert.Contains(t, string(data), fn+"_amd64.deb\n")
+	}
+
+	data, err = ioutil.ReadFile(filepath.Join(root, "dists", "staging", "main", "binary-amd64", "Packages"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "Package: app\n")
+
+	require.NoError(t, a.Close())
+	require.NoError(t, b.Close())
+}
+
+func TestUnlockFailed(t *testing.T) {
+	l := newTestLimbo(t)
+
+	_, err := l.UploadTo(bytes.NewReader(packageBytes(t, "app", "1.0", "amd64")), "staging", "")
+	require.NoError(t, err)
+
+	gen := func() int64 {
+		g, err := readGen(l.lockf)
+		require.NoError(t, err)
+
+		return g
+	}
+
+	g := gen()
+
+	_, err = l.GC(true)
+	require.NoError(t, err)
+
+	_, err = l.CreateSnapshot("staging", "s1")
+	require.NoError(t, err)
+
+	assert.Equal(t, g, gen(), "read only operations bumped generation")
+
+	data, err := ioutil.ReadFile(l.suitesFile())
+	require.NoError(t, err)
+
+	// suites can't be saved over a dir
+	require.NoError(t, os.Remove(l.suitesFile()))
+	require.NoError(t, os.MkdirAll(filepath.Join(l.suitesFile(), "dir"), 0755))
+
+	_, err = l.Promote("app", "1.0", "staging", "stable")
+	require.Error(t, err)
+
+	require.NoError(t, os.RemoveAll(l.suitesFile()))
+	require.NoError(t, ioutil.WriteFile(l.suitesFile(), data, 0644))
+
+	// failed promotion is not taken as saved
+	_, err = l.Promote("app", "1.0", "staging", "stable")
+	require.NoError(t, err)
+}
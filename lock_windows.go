@@ -0,0 +1,10 @@
+package limbo
+
+import "os"
+
+// Repo is not locked across processes on windows.
+// Only one process may write it at a time.
+
+func flock(f *os.File) error { return nil }
+
+func funlock(f *os.File) error { return nil }
@@ -0,0 +1,173 @@
+package limbo
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type (
+	metrics struct {
+		uploads *prometheus.CounterVec
+		publish *prometheus.HistogramVec
+
+		packages  *prometheus.Desc
+		poolFiles *prometheus.Desc
+		poolBytes *prometheus.Desc
+
+		// state is taken after every repo write, so scrapes don't wait for writes in progress.
+		mu    sync.Mutex
+		state repoStats
+
+		// usage is pool dir size, walked by scrapes at most once in poolUsageTTL.
+		usageMu sync.Mutex
+		usage   int64
+		usageAt time.Time
+	}
+
+	repoStats struct {
+		packages  map[[2]string]int // suite, arch -> count
+		poolFiles int
+	}
+)
+
+const poolUsageTTL = time.Minute
+
+func newMetrics() *metrics {
+	return &metrics{
+		uploads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "limbo",
+			Name:      "uploads_total",
+			Help:      "Package uploads by result: ok or rejection reason.",
+		}, []string{"result"}),
+		publish: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "limbo",
+			Name:      "index_publish_duration_seconds",
+			Help:      "Suite indexes and Release regeneration time.",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 4, 8),
+		}, []string{"suite"}),
+		packages: prometheus.NewDesc("limbo_packages",
+			"Packages referenced by suite.", []string{"suite", "arch"}, nil),
+		poolFiles: prometheus.NewDesc("limbo_pool_files",
+			"Package files indexed in the pool.", nil, nil),
+		poolBytes: prometheus.NewDesc("limbo_pool_bytes",
+			"Pool disk usage: all the files including unreferenced and temporary ones.", nil, nil),
+	}
+}
+
+// Describe is prometheus.Collector implementation.
+func (l *Limbo) Describe(ch chan<- *prometheus.Desc) {
+	l.metrics.uploads.Describe(ch)
+	l.metrics.publish.Describe(ch)
+
+	ch <- l.metrics.packages
+	ch <- l.metrics.poolFiles
+	ch <- l.metrics.poolBytes
+}
+
+// Collect is prometheus.Collector implementation.
+// Package counts and pool usage are taken from the state after the last repo write.
+func (l *Limbo) Collect(ch chan<- prometheus.Metric) {
+	l.metrics.uploads.Collect(ch)
+	l.metrics.publish.Collect(ch)
+
+	l.metrics.mu.Lock()
+	st := l.metrics.state
+	l.metrics.mu.Unlock()
+
+	for k, n := range st.packages {
+		ch <- prometheus.MustNewConstMetric(l.metrics.packages, prometheus.GaugeValue, float64(n), k[0], k[1])
+	}
+
+	ch <- prometheus.MustNewConstMetric(l.metrics.poolFiles, prometheus.GaugeValue, float64(st.poolFiles))
+
+	size, err := l.poolUsage()
+	if err != nil {
+		l.tr.Printw("pool usage", "err", err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(l.metrics.poolBytes, prometheus.GaugeValue, float64(size))
+}
+
+// poolUsage returns total size of files in the pool dir.
+// The repo is not locked, files removed while walking are skipped.
+func (l *Limbo) poolUsage() (size int64, err error) {
+	m := l.metrics
+
+	defer m.usageMu.Unlock()
+	m.usageMu.Lock()
+
+	if !m.usageAt.IsZero() && time.Since(m.usageAt) < poolUsageTTL {
+		return m.usage, nil
+	}
+
+	err = filepath.Walk(l.Pool, func(p string, inf os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if !inf.IsDir() {
+			size += inf.Size()
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "walk pool")
+	}
+
+	m.usage, m.usageAt = size, time.Now()
+
+	return size, nil
+}
+
+// updateStats updates the state reported by Collect. l.mu must be held.
+func (l *Limbo) updateStats() {
+	st := repoStats{
+		packages:  map[[2]string]int{},
+		poolFiles: len(l.pkgs),
+	}
+
+	for s, d := range l.suites {
+		for _, set := range d {
+			for fn := range set {
+				if p := l.pkgs[fn]; p != nil {
+					st.packages[[2]string{s, p.Control.Architecture}]++
+				}
+			}
+		}
+	}
+
+	defer l.metrics.mu.Unlock()
+	l.metrics.mu.Lock()
+
+	l.metrics.state = st
+}
+
+func (m *metrics) upload(err error) {
+	res := "ok"
+
+	var pe *PackageError
+
+	switch {
+	case err == nil:
+	case errors.As(err, &pe):
+		res = pe.Reason
+	default:
+		res = "error"
+	}
+
+	m.uploads.WithLabelValues(res).Inc()
+}
+
+func (m *metrics) published(suite string, start time.Time) {
+	m.publish.WithLabelValues(suite).Observe(time.Since(start).Seconds())
+}
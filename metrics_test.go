@@ -0,0 +1,98 @@
+package limbo
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	l := newTestLimbo(t)
+
+	for _, p := range [][]byte{
+		packageBytes(t, "app", "1.0", "amd64"),
+		packageBytes(t, "app", "1.0", "arm64"),
+		packageBytes(t, "data", "1.0", "all"),
+	} {
+		_, err := l.Upload(bytes.NewReader(p))
+		require.NoError(t, err)
+	}
+
+	_, err := l.Upload(strings.NewReader("not a deb"))
+	require.Error(t, err)
+
+	assert.Equal(t, 3.0, testutil.ToFloat64(l.metrics.uploads.WithLabelValues("ok")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(l.metrics.uploads.WithLabelValues(ReasonMalformed)))
+
+	var size int
+	for _, p := range l.pkgs {
+		size += int(p.Size)
+	}
+
+	// interrupted upload is counted in disk usage
+	err = ioutil.WriteFile(filepath.Join(l.Pool, ".upload-1.tmp"), make([]byte, 100), 0644)
+	require.NoError(t, err)
+
+	size += 100
+
+	err = testutil.CollectAndCompare(l, strings.NewReader(`
+# HELP limbo_packages Packages referenced by suite.
+# TYPE limbo_packages gauge
+limbo_packages{arch="all",suite="stable"} 1
+limbo_packages{arch="amd64",suite="stable"} 1
+limbo_packages{arch="arm64",suite="stable"} 1
+# HELP limbo_pool_files Package files indexed in the pool.
+# TYPE limbo_pool_files gauge
+limbo_pool_files 3
+`), "limbo_packages", "limbo_pool_files")
+	assert.NoError(t, err)
+
+	assert.Equal(t, float64(size), gauge(t, l, "limbo_pool_bytes"))
+	assert.NotZero(t, testutil.CollectAndCount(l.metrics.publish))
+
+	// scrape doesn't wait for a write in progress
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		testutil.CollectAndCount(l)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("collect blocked by repo lock")
+	}
+}
+
+func gauge(t *testing.T, l *Limbo, name string) float64 {
+	t.Helper()
+
+	reg := prometheus.NewPedanticRegistry()
+	require.NoError(t, reg.Register(l))
+
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+
+	for _, mf := range mfs {
+		if mf.GetName() == name {
+			return mf.GetMetric()[0].GetGauge().GetValue()
+		}
+	}
+
+	t.Fatalf("no metric %v", name)
+
+	return 0
+}
@@ -0,0 +1,370 @@
+package limbo
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/openpgp"
+)
+
+type (
+	// MirrorOptions selects what part of upstream repo to mirror.
+	MirrorOptions struct {
+		URL        string
+		Suite      string   // upstream suite
+		Components []string // main if empty
+		Archs      []string // required
+
+		Into string // local suite, upstream one if empty
+
+		Packages   []string // name glob patterns, all if empty
+		Priorities []string // all if empty
+
+		// Prune drops packages gone from upstream from the local suite components.
+		Prune bool
+
+		Keyring openpgp.EntityList
+		Client  *http.Client
+	}
+
+	// MirrorReport summarizes mirror sync.
+	MirrorReport struct {
+		Packages   int   // packages selected
+		Downloaded int   // files downloaded
+		Size       int64 // bytes downloaded
+		Failed     []string
+		Pruned     int
+	}
+)
+
+// Mirror downloads upstream indexes and the selected packages into the pool
+// and references them from the local suite.
+// Every file is checked against upstream Release and Packages hashes.
+// Files already in the pool are skipped, so interrupted sync is resumed by running it again.
+func (l *Limbo) Mirror(o MirrorOptions) (rep *MirrorReport, err error) {
+	if o.Into == "" {
+		o.Into = o.Suite
+	}
+
+	if len(o.Components) == 0 {
+		o.Components = []string{"main"}
+	}
+
+	if len(o.Archs) == 0 {
+		return nil, errors.New("no architectures")
+	}
+
+	for _, n := range append([]string{o.Suite, o.Into}, append(o.Components, o.Archs...)...) {
+		if !validName(n, nameChars) {
+			return nil, errors.Errorf("bad name: %q", n)
+		}
+	}
+
+	for _, pat := range o.Packages {
+		if _, err = path.Match(pat, ""); err != nil {
+			return nil, errors.Wrapf(err, "package pattern %q", pat)
+		}
+	}
+
+	p := NewProxy(l.ctx, o.URL, filepath.Join(l.Path, "mirror", mirrorDir(o.URL)))
+	p.TTL = 0
+	p.Keyring = o.Keyring
+
+	if o.Client != nil {
+		p.Client = o.Client
+	}
+
+	rel, err := p.suiteRelease(o.Suite)
+	if err != nil {
+		return nil, errors.Wrap(err, "get release")
+	}
+
+	rep = &MirrorReport{}
+
+	want := map[string]map[string]*Package{} // component -> Filename ->
+
+	for _, c := range o.Components {
+		want[c] = map[string]*Package{}
+
+		for _, a := range o.Archs {
+			idx := pickIndex(rel, path.Join(c, "binary-"+a, "Packages"))
+			if idx == "" {
+				return nil, errors.Errorf("no %v/%v index in upstream release", c, a)
+			}
+
+			pkgs, err := p.readIndex(o.Suite, idx)
+			if err != nil {
+				return nil, errors.Wrapf(err, "read %v", idx)
+			}
+
+			for _, pkg := range pkgs {
+				if o.selected(pkg) {
+					want[c][pkg.Filename] = pkg
+				}
+			}
+		}
+
+		rep.Packages += len(want[c])
+	}
+
+	l.tr.Printw("mirror", "url", o.URL, "suite", o.Suite, "into", o.Into, "packages", rep.Packages)
+
+	// files downloaded by an interrupted sync must not be assigned to the default suite on Load
+	err = l.markPending(want)
+	if err != nil {
+		return nil, errors.Wrap(err, "save suites")
+	}
+
+	got := map[string]fileSet{}
+
+	for _, c := range o.Components {
+		got[c] = fileSet{}
+
+		for _, fn := range pkgsFilenames(want[c]) {
+			pkg := want[c][fn]
+
+			n, err := l.mirrorFile(p, pkg)
+			if errors.Is(err, ErrClosed) {
+				return rep, err
+			}
+			if err != nil {
+				l.tr.Printw("mirror file", "file", fn, "err", err)
+
+				rep.Failed = append(rep.Failed, fn)
+
+				continue
+			}
+
+			if n != 0 {
+				rep.Downloaded++
+				rep.Size += n
+			}
+
+			got[c][fn] = struct{}{}
+		}
+	}
+
+	err = l.lock()
+	if err != nil {
+		return rep, err
+	}
+
+	defer func() {
+		l.unlock(err)
+	}()
+
+	d := l.dist(o.Into)
+
+	for _, c := range o.Components {
+		set := d.component(c)
+
+		for fn := range got[c] {
+			set[fn] = struct{}{}
+			delete(l.orphans, fn)
+		}
+
+		if !o.Prune {
+			continue
+		}
+
+		for fn := range set {
+			if _, ok := want[c][fn]; ok {
+				continue
+			}
+
+			delete(set, fn)
+			rep.Pruned++
+
+			l.orphan(fn)
+		}
+	}
+
+	err = l.saveCache(l.cache)
+	if err != nil {
+		return rep, errors.Wrap(err, "save pool cache")
+	}
+
+	err = l.saveSuites()
+	if err != nil {
+		return rep, errors.Wrap(err, "save suites")
+	}
+
+	err = l.publish(o.Into, o.Components, uniq(append(l.archs(o.Into), o.Archs...)))
+	if err != nil {
+		return rep, errors.Wrap(err, "publish")
+	}
+
+	if len(rep.Failed) != 0 {
+		return rep, errors.Errorf("%d files failed", len(rep.Failed))
+	}
+
+	return rep, nil
+}
+
+// markPending marks files not in the pool yet as orphans until they are referenced.
+func (l *Limbo) markPending(want map[string]map[string]*Package) (err error) {
+	err = l.lock()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		l.unlock(err)
+	}()
+
+	for _, pkgs := range want {
+		for fn := range pkgs {
+			if _, ok := l.pkgs[fn]; !ok {
+				l.orphan(fn)
+			}
+		}
+	}
+
+	return l.saveSuites()
+}
+
+// mirrorFile downloads pool file if it's not in the pool already.
+// Downloaded bytes are returned.
+func (l *Limbo) mirrorFile(p *Proxy, pkg *Package) (n int64, err error) {
+	l.mu.Lock()
+	old, closed := l.pkgs[pkg.Filename], l.closed
+	l.mu.Unlock()
+
+	if closed {
+		return 0, ErrClosed
+	}
+
+	if old != nil {
+		if old.SHA256Sum != pkg.SHA256Sum {
+			return 0, &PackageError{Reason: ReasonConflict, Err: errors.Errorf("%v exists with different content", pkg.Filename)}
+		}
+
+		return 0, nil
+	}
+
+	if !strings.HasPrefix(pkg.Filename, "pool/") || path.Clean(pkg.Filename) != pkg.Filename {
+		return 0, &PackageError{Reason: ReasonMalformed, Err: errors.Errorf("bad filename: %q", pkg.Filename)}
+	}
+
+	local := filepath.Join(l.Path, filepath.FromSlash(pkg.Filename))
+
+	err = p.fetchVerified(pkg.Filename, local, &indexFile{Name: pkg.Filename, Size: pkg.Size, SHA256Sum: pkg.SHA256Sum})
+	if err != nil {
+		return 0, err
+	}
+
+	lp, err := l.readPoolFile(local)
+	if err != nil {
+		return 0, errors.Wrap(err, "read package")
+	}
+
+	inf, err := os.Stat(local)
+	if err != nil {
+		return 0, errors.Wrap(err, "stat")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.pkgs == nil {
+		l.pkgs = map[string]*Package{}
+	}
+
+	if l.cache == nil {
+		l.cache = poolCache{}
+	}
+
+	l.pkgs[lp.Filename] = lp
+	l.cache.add(lp, inf)
+
+	return lp.Size, nil
+}
+
+// readIndex reads verified upstream suite index.
+func (p *Proxy) readIndex(suite, rel string) (pkgs []*Package, err error) {
+	local, err := p.index(suite, rel)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(local)
+	if err != nil {
+		return nil, errors.Wrap(err, "open")
+	}
+	defer func() {
+		e := f.Close()
+		if err == nil {
+			err = errors.Wrap(e, "close")
+		}
+	}()
+
+	r, err := decompress(f, rel)
+	if err != nil {
+		return nil, err
+	}
+
+	return ReadPackages(r)
+}
+
+func (o *MirrorOptions) selected(p *Package) bool {
+	if len(o.Priorities) != 0 && !contains(o.Priorities, p.Control.Priority) {
+		return false
+	}
+
+	if len(o.Packages) == 0 {
+		return true
+	}
+
+	for _, pat := range o.Packages {
+		if ok, _ := path.Match(pat, p.Control.Package); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pickIndex returns the best compressed variant of index base listed in the release.
+func pickIndex(r *upstreamRelease, base string) string {
+	for _, ext := range []string{".xz", ".gz", ""} {
+		if _, ok := r.files[base+ext]; ok {
+			return base + ext
+		}
+	}
+
+	return ""
+}
+
+// mirrorDir returns cache dir name for upstream url.
+func mirrorDir(u string) string {
+	if i := strings.Index(u, "://"); i != -1 {
+		u = u[i+3:]
+	}
+
+	return strings.NewReplacer("/", "_", ":", "_").Replace(strings.Trim(u, "/"))
+}
+
+func pkgsFilenames(m map[string]*Package) (l []string) {
+	for k := range m {
+		l = append(l, k)
+	}
+
+	sort.Strings(l)
+
+	return l
+}
+
+func contains(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
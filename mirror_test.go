@@ -0,0 +1,99 @@
+package limbo
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMirror(t *testing.T) {
+	root, err := ioutil.TempDir("", "limbo_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	up, err := New(context.Background(), filepath.Join(root, "upstream"))
+	require.NoError(t, err)
+
+	up.Suite = "bookworm"
+
+	err = up.UpdateIndex()
+	require.NoError(t, err)
+
+	for _, p := range [][3]string{
+		{"app", "1.0", "amd64"},
+		{"libfoo", "2.0", "amd64"},
+		{"libfoo", "2.0", "arm64"},
+		{"doc", "1.0", "all"},
+	} {
+		_, err = up.Upload(bytes.NewReader(packageBytes(t, p[0], p[1], p[2])))
+		require.NoError(t, err)
+	}
+
+	srv := httptest.NewServer(http.FileServer(http.Dir(up.Path)))
+	defer srv.Close()
+
+	l, err := New(context.Background(), filepath.Join(root, "local"))
+	require.NoError(t, err)
+
+	err = l.Load()
+	require.NoError(t, err)
+
+	opts := MirrorOptions{
+		URL:      srv.URL,
+		Suite:    "bookworm",
+		Archs:    []string{"amd64"},
+		Into:     "upstream",
+		Packages: []string{"lib*", "doc"},
+		Prune:    true,
+	}
+
+	rep, err := l.Mirror(opts)
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, rep.Packages)
+	assert.Equal(t, 2, rep.Downloaded)
+
+	idx, err := ioutil.ReadFile(filepath.Join(l.Dists, "upstream", "main", "binary-amd64", "Packages"))
+	require.NoError(t, err)
+
+	assert.Contains(t, string(idx), "Filename: pool/main/libf/libfoo/libfoo_2.0_amd64.deb\n")
+	assert.Contains(t, string(idx), "Package: doc\n")
+	assert.NotContains(t, string(idx), "Package: app\n")
+
+	// resumed sync downloads nothing
+	rep, err = l.Mirror(opts)
+	require.NoError(t, err)
+	assert.Equal(t, 0, rep.Downloaded)
+
+	// tampered upstream file is rejected
+	opts.Packages = nil
+
+	err = ioutil.WriteFile(filepath.Join(up.Path, "pool/main/a/app/app_1.0_amd64.deb"), []byte("evil"), 0644)
+	require.NoError(t, err)
+
+	rep, err = l.Mirror(opts)
+	assert.Error(t, err)
+	assert.Equal(t, []string{"pool/main/a/app/app_1.0_amd64.deb"}, rep.Failed)
+
+	_, err = os.Stat(filepath.Join(l.Path, "pool/main/a/app/app_1.0_amd64.deb"))
+	assert.True(t, os.IsNotExist(err), "%v", err)
+
+	// pruned
+	opts.Packages = []string{"doc"}
+
+	rep, err = l.Mirror(opts)
+	require.NoError(t, err)
+	assert.Equal(t, 1, rep.Pruned)
+
+	idx, err = ioutil.ReadFile(filepath.Join(l.Dists, "upstream", "main", "binary-amd64", "Packages"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(idx), "Package: libfoo\n")
+}
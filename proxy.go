@@ -0,0 +1,666 @@
+package limbo
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nikandfor/tlog"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+)
+
+type (
+	// Proxy is a pull-through cache of an upstream apt repository.
+	//
+	// Release files are fetched on request and refetched after TTL.
+	// Indexes are verified against the Release SHA256 sums and
+	// pool files against the indexes ones.
+	// Verified files are stored in Dir and served from there afterwards.
+	Proxy struct {
+		Upstream string
+		Dir      string
+		TTL      time.Duration
+
+		// Keyring verifies Release signatures if set.
+		Keyring openpgp.EntityList
+
+		Client *http.Client
+
+		tr tlog.Span
+
+		mu       sync.Mutex
+		releases map[string]*upstreamRelease // suite ->
+		pool     map[string]*indexFile       // Filename ->
+		scanned  map[string]struct{}         // indexes sha256
+		flight   map[string]*flight
+	}
+
+	upstreamRelease struct {
+		fetched time.Time
+		files   map[string]*indexFile // path relative to the suite dir ->
+	}
+
+	flight struct {
+		done chan struct{}
+		err  error
+	}
+
+	// upstreamError is an error with HTTP status to respond with.
+	upstreamError struct {
+		status int
+		err    error
+	}
+)
+
+// NewProxy creates proxy to upstream repo url caching files in dir.
+func NewProxy(ctx context.Context, upstream, dir string) *Proxy {
+	return &Proxy{
+		Upstream: strings.TrimSuffix(upstream, "/"),
+		Dir:      dir,
+		TTL:      5 * time.Minute,
+		Client:   newUpstreamClient(),
+
+		tr: tlog.SpawnOrStartFromContext(ctx, "proxy"),
+
+		releases: map[string]*upstreamRelease{},
+		pool:     map[string]*indexFile{},
+		scanned:  map[string]struct{}{},
+		flight:   map[string]*flight{},
+	}
+}
+
+// newUpstreamClient returns client which gives up on stalled upstream.
+// Total timeout is generous as pool files may be big.
+func newUpstreamClient() *http.Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ResponseHeaderTimeout = 30 * time.Second
+
+	return &http.Client{
+		Transport: t,
+		Timeout:   10 * time.Minute,
+	}
+}
+
+// ServeHTTP serves repo file by the request path relative to the repo root.
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	name := path.Clean("/" + req.URL.Path)[1:]
+
+	fn, err := p.File(name)
+	if err != nil {
+		status := http.StatusBadGateway
+
+		var ue *upstreamError
+		if errors.As(err, &ue) {
+			status = ue.status
+		}
+
+		p.tr.Printw("proxy", "file", name, "status", status, "err", err)
+
+		http.Error(w, err.Error(), status)
+
+		return
+	}
+
+	http.ServeFile(w, req, fn)
+}
+
+// File returns local path of the verified repo file fetching it if needed.
+func (p *Proxy) File(name string) (string, error) {
+	parts := strings.SplitN(name, "/", 3)
+
+	switch {
+	case len(parts) == 3 && parts[0] == "dists" && releaseFiles[parts[2]]:
+		return p.release(parts[1], parts[2])
+	case len(parts) == 3 && parts[0] == "dists":
+		return p.index(parts[1], parts[2])
+	case len(parts) > 1 && parts[0] == "pool":
+		return p.poolFile(name)
+	default:
+		return "", &upstreamError{status: http.StatusNotFound, err: errors.Errorf("not a repo file: %v", name)}
+	}
+}
+
+// release returns local copy of a Release file refreshing it after TTL.
+func (p *Proxy) release(suite, fname string) (string, error) {
+	name := path.Join("dists", suite, fname)
+	local := filepath.Join(p.Dir, filepath.FromSlash(name))
+
+	if inf, err := os.Stat(local); err == nil && time.Since(inf.ModTime()) < p.TTL {
+		return local, nil
+	}
+
+	err := p.once(name, func() error {
+		return p.fetchRelease(suite, fname)
+	})
+	if err != nil {
+		if _, e := os.Stat(local); e == nil {
+			p.tr.Printw("serve stale", "file", name, "err", err)
+
+			return local, nil
+		}
+
+		return "", err
+	}
+
+	return local, nil
+}
+
+func (p *Proxy) fetchRelease(suite, fname string) (err error) {
+	dir := filepath.Join(p.Dir, "dists", suite)
+
+	data, err := p.fetchAll(path.Join("dists", suite, fname))
+	if err != nil {
+		return err
+	}
+
+	text := data
+
+	switch fname {
+	case "InRelease":
+		b, _ := clearsign.Decode(data)
+		if b == nil {
+			return &upstreamError{status: http.StatusBadGateway, err: errors.New("InRelease is not clearsigned")}
+		}
+
+		if p.Keyring != nil {
+			_, err = openpgp.CheckDetachedSignature(p.Keyring, bytes.NewReader(b.Bytes), b.ArmoredSignature.Body)
+			if err != nil {
+				return &upstreamError{status: http.StatusBadGateway, err: errors.Wrap(err, "verify InRelease")}
+			}
+		}
+
+		text = b.Plaintext
+	case "Release":
+		if p.Keyring != nil {
+			sig, err := p.fetchAll(path.Join("dists", suite, "Release.gpg"))
+			if err != nil {
+				return errors.Wrap(err, "Release.gpg")
+			}
+
+			_, err = openpgp.CheckArmoredDetachedSignature(p.Keyring, bytes.NewReader(data), bytes.NewReader(sig))
+			if err != nil {
+				return &upstreamError{status: http.StatusBadGateway, err: errors.Wrap(err, "verify Release")}
+			}
+
+			err = writeFileAtomic(filepath.Join(dir, "Release.gpg"), sig, 0644)
+			if err != nil {
+				return errors.Wrap(err, "save Release.gpg")
+			}
+		}
+	case "Release.gpg":
+		return writeFileAtomic(filepath.Join(dir, fname), data, 0644)
+	}
+
+	files, err := parseReleaseSums(text)
+	if err != nil {
+		return &upstreamError{status: http.StatusBadGateway, err: errors.Wrapf(err, "parse %v", fname)}
+	}
+
+	err = writeFileAtomic(filepath.Join(dir, fname), data, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "save %v", fname)
+	}
+
+	p.mu.Lock()
+	p.releases[suite] = &upstreamRelease{
+		fetched: time.Now(),
+		files:   files,
+	}
+	p.mu.Unlock()
+
+	p.tr.Printw("release fetched", "suite", suite, "file", fname, "indexes", len(files))
+
+	return nil
+}
+
+// suiteRelease returns checksums of the suite indexes from the freshest Release file.
+func (p *Proxy) suiteRelease(suite string) (*upstreamRelease, error) {
+	p.mu.Lock()
+	r := p.releases[suite]
+	p.mu.Unlock()
+
+	if r != nil && time.Since(r.fetched) < p.TTL {
+		return r, nil
+	}
+
+	var err error
+
+	for _, fname := range []string{"InRelease", "Release"} {
+		var local string
+
+		local, err = p.release(suite, fname)
+		if err != nil {
+			continue
+		}
+
+		p.mu.Lock()
+		r = p.releases[suite]
+		p.mu.Unlock()
+
+		if r != nil {
+			return r, nil
+		}
+
+		// served from cache after restart
+		data, err := ioutil.ReadFile(local)
+		if err != nil {
+			return nil, errors.Wrap(err, "read cached release")
+		}
+
+		if b, _ := clearsign.Decode(data); b != nil {
+			data = b.Plaintext
+		}
+
+		files, err := parseReleaseSums(data)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse cached release")
+		}
+
+		inf, err := os.Stat(local)
+		if err != nil {
+			return nil, errors.Wrap(err, "stat cached release")
+		}
+
+		r = &upstreamRelease{fetched: inf.ModTime(), files: files}
+
+		p.mu.Lock()
+		p.releases[suite] = r
+		p.mu.Unlock()
+
+		return r, nil
+	}
+
+	return nil, err
+}
+
+// index returns local copy of a suite index file verified against the Release.
+// Index files are stored by their SHA256 so they are never stale.
+func (p *Proxy) index(suite, rel string) (string, error) {
+	r, err := p.suiteRelease(suite)
+	if err != nil {
+		return "", errors.Wrap(err, "get release")
+	}
+
+	var f *indexFile
+
+	if dir, h := path.Split(rel); path.Base(dir) == "SHA256" && path.Base(path.Dir(dir)) == "by-hash" {
+		for _, rf := range r.files {
+			if rf.SHA256Sum == h {
+				f = rf
+				break
+			}
+		}
+	} else {
+		f = r.files[rel]
+	}
+
+	if f == nil {
+		return "", &upstreamError{status: http.StatusNotFound, err: errors.Errorf("%v is not in %v Release", rel, suite)}
+	}
+
+	local := filepath.Join(p.Dir, "by-hash", f.SHA256Sum)
+
+	if _, err = os.Stat(local); err != nil {
+		err = p.once(local, func() error {
+			return p.fetchVerified(path.Join("dists", suite, rel), local, f)
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if isPackagesIndex(f.Name) {
+		err = p.scanIndex(local, f)
+		if err != nil {
+			return "", errors.Wrapf(err, "scan %v", rel)
+		}
+	}
+
+	return local, nil
+}
+
+// poolFile returns local copy of a pool file verified against the indexes.
+func (p *Proxy) poolFile(name string) (string, error) {
+	local := filepath.Join(p.Dir, filepath.FromSlash(name))
+
+	if _, err := os.Stat(local); err == nil {
+		return local, nil
+	}
+
+	p.mu.Lock()
+	f := p.pool[name]
+	p.mu.Unlock()
+
+	if f == nil {
+		err := p.scanCached()
+		if err != nil {
+			return "", errors.Wrap(err, "scan cached indexes")
+		}
+
+		p.mu.Lock()
+		f = p.pool[name]
+		p.mu.Unlock()
+	}
+
+	if f == nil {
+		return "", &upstreamError{status: http.StatusNotFound, err: errors.Errorf("%v is not in any fetched index", name)}
+	}
+
+	err := p.once(name, func() error {
+		return p.fetchVerified(name, local, f)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return local, nil
+}
+
+// scanCached scans cached indexes of suites fetched before, after restart for example.
+func (p *Proxy) scanCached() error {
+	suites, err := ioutil.ReadDir(filepath.Join(p.Dir, "dists"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, s := range suites {
+		if !s.IsDir() {
+			continue
+		}
+
+		r, err := p.suiteRelease(s.Name())
+		if err != nil {
+			p.tr.Printw("scan cached suite", "suite", s.Name(), "err", err)
+			continue
+		}
+
+		for _, f := range r.files {
+			if !isPackagesIndex(f.Name) {
+				continue
+			}
+
+			local := filepath.Join(p.Dir, "by-hash", f.SHA256Sum)
+
+			if _, err := os.Stat(local); err != nil {
+				continue
+			}
+
+			err = p.scanIndex(local, f)
+			if err != nil {
+				return errors.Wrapf(err, "scan %v", f.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// scanIndex remembers pool files checksums from Packages index once.
+func (p *Proxy) scanIndex(local string, f *indexFile) (err error) {
+	p.mu.Lock()
+	_, ok := p.scanned[f.SHA256Sum]
+	p.mu.Unlock()
+
+	if ok {
+		return nil
+	}
+
+	file, err := os.Open(local)
+	if err != nil {
+		return errors.Wrap(err, "open")
+	}
+	defer func() {
+		e := file.Close()
+		if err == nil {
+			err = errors.Wrap(e, "close")
+		}
+	}()
+
+	r, err := decompress(file, f.Name)
+	if err != nil {
+		return err
+	}
+
+	files := map[string]*indexFile{}
+	cur := &indexFile{}
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64<<10), 16<<20)
+
+	for s.Scan() {
+		l := s.Text()
+
+		switch {
+		case strings.TrimSpace(l) == "":
+			if cur.Name != "" && cur.SHA256Sum != "" {
+				files[cur.Name] = cur
+			}
+
+			cur = &indexFile{}
+		case strings.HasPrefix(l, "Filename:"):
+			cur.Name = strings.TrimSpace(l[len("Filename:"):])
+		case strings.HasPrefix(l, "SHA256:"):
+			cur.SHA256Sum = strings.TrimSpace(l[len("SHA256:"):])
+		case strings.HasPrefix(l, "Size:"):
+			cur.Size, _ = strconv.ParseInt(strings.TrimSpace(l[len("Size:"):]), 10, 64)
+		}
+	}
+
+	if err = s.Err(); err != nil {
+		return errors.Wrap(err, "read")
+	}
+
+	if cur.Name != "" && cur.SHA256Sum != "" {
+		files[cur.Name] = cur
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for n, pf := range files {
+		p.pool[n] = pf
+	}
+
+	p.scanned[f.SHA256Sum] = struct{}{}
+
+	p.tr.Printw("index scanned", "index", f.Name, "files", len(files))
+
+	return nil
+}
+
+// fetchVerified downloads upstream file name to local checking its size and SHA256.
+func (p *Proxy) fetchVerified(name, local string, f *indexFile) (err error) {
+	resp, err := p.get(name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		e := resp.Body.Close()
+		if err == nil {
+			err = errors.Wrap(e, "close body")
+		}
+	}()
+
+	err = os.MkdirAll(filepath.Dir(local), 0755)
+	if err != nil {
+		return errors.Wrap(err, "create dir")
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(local), "."+filepath.Base(local)+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "create temp file")
+	}
+	defer func() {
+		_ = tmp.Close()
+
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	h := sha256.New()
+
+	n, err := io.Copy(io.MultiWriter(tmp, h), resp.Body)
+	if err != nil {
+		return &upstreamError{status: http.StatusBadGateway, err: errors.Wrap(err, "download")}
+	}
+
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != f.SHA256Sum || f.Size != 0 && n != f.Size {
+		return &upstreamError{status: http.StatusBadGateway, err: errors.Errorf("%v: checksum mismatch: got %v (%d bytes), expected %v (%d bytes)", name, sum, n, f.SHA256Sum, f.Size)}
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return errors.Wrap(err, "close temp file")
+	}
+
+	err = os.Rename(tmp.Name(), local)
+	if err != nil {
+		return errors.Wrap(err, "rename")
+	}
+
+	p.tr.Printw("file cached", "file", name, "size", n)
+
+	return nil
+}
+
+func (p *Proxy) fetchAll(name string) (_ []byte, err error) {
+	resp, err := p.get(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		e := resp.Body.Close()
+		if err == nil {
+			err = errors.Wrap(e, "close body")
+		}
+	}()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &upstreamError{status: http.StatusBadGateway, err: errors.Wrap(err, "read body")}
+	}
+
+	return data, nil
+}
+
+func (p *Proxy) get(name string) (*http.Response, error) {
+	resp, err := p.Client.Get(p.Upstream + "/" + name)
+	if err != nil {
+		return nil, &upstreamError{status: http.StatusBadGateway, err: errors.Wrap(err, "get")}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+
+		status := http.StatusBadGateway
+		if resp.StatusCode == http.StatusNotFound {
+			status = http.StatusNotFound
+		}
+
+		return nil, &upstreamError{status: status, err: errors.Errorf("get %v: %v", name, resp.Status)}
+	}
+
+	return resp, nil
+}
+
+// once runs f for key, concurrent callers with the same key wait for the first one.
+func (p *Proxy) once(key string, f func() error) error {
+	p.mu.Lock()
+
+	if c, ok := p.flight[key]; ok {
+		p.mu.Unlock()
+
+		<-c.done
+
+		return c.err
+	}
+
+	c := &flight{done: make(chan struct{})}
+	p.flight[key] = c
+
+	p.mu.Unlock()
+
+	c.err = f()
+
+	p.mu.Lock()
+	delete(p.flight, key)
+	p.mu.Unlock()
+
+	close(c.done)
+
+	return c.err
+}
+
+// parseReleaseSums parses SHA256 section of a Release file.
+func parseReleaseSums(data []byte) (map[string]*indexFile, error) {
+	files := map[string]*indexFile{}
+	in := false
+
+	for _, l := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(l, " ") {
+			in = strings.TrimSpace(l) == "SHA256:"
+			continue
+		}
+
+		if !in {
+			continue
+		}
+
+		f := strings.Fields(l)
+		if len(f) != 3 {
+			return nil, errors.Errorf("bad SHA256 line: %q", l)
+		}
+
+		size, err := strconv.ParseInt(f[1], 10, 64)
+		if err != nil {
+			return nil, errors.Errorf("bad SHA256 line: %q", l)
+		}
+
+		files[f[2]] = &indexFile{
+			Name:      f[2],
+			Size:      size,
+			SHA256Sum: f[0],
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, errors.New("no SHA256 sums")
+	}
+
+	return files, nil
+}
+
+func isPackagesIndex(name string) bool {
+	switch path.Base(name) {
+	case "Packages", "Packages.gz", "Packages.xz":
+		return true
+	default:
+		return false
+	}
+}
+
+func (e *upstreamError) Error() string {
+	return e.err.Error()
+}
+
+func (e *upstreamError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,147 @@
+package limbo
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/openpgp"
+)
+
+func TestProxy(t *testing.T) {
+	root, err := ioutil.TempDir("", "limbo_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	up, err := New(context.Background(), filepath.Join(root, "upstream"))
+	require.NoError(t, err)
+
+	up.Signer = testSigner(t)
+
+	err = up.UpdateIndex()
+	require.NoError(t, err)
+
+	for _, v := range []string{"1.0", "2.0"} {
+		_, err = up.Upload(bytes.NewReader(packageBytes(t, "app", v, "amd64")))
+		require.NoError(t, err)
+	}
+
+	var hits int32
+
+	fs := http.FileServer(http.Dir(up.Path))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		fs.ServeHTTP(w, req)
+	}))
+	defer srv.Close()
+
+	var pub bytes.Buffer
+
+	err = up.Signer.WritePublicKey(&pub)
+	require.NoError(t, err)
+
+	p := NewProxy(context.Background(), srv.URL, filepath.Join(root, "cache"))
+
+	p.Keyring, err = openpgp.ReadArmoredKeyRing(&pub)
+	require.NoError(t, err)
+
+	get := func(name string) (int, []byte) {
+		w := httptest.NewRecorder()
+
+		p.ServeHTTP(w, httptest.NewRequest("GET", "/"+name, nil))
+
+		return w.Code, w.Body.Bytes()
+	}
+
+	same := func(name string) {
+		t.Helper()
+
+		code, body := get(name)
+		require.Equal(t, http.StatusOK, code, "%s: %s", name, body)
+
+		exp, err := ioutil.ReadFile(filepath.Join(up.Path, filepath.FromSlash(name)))
+		require.NoError(t, err)
+
+		assert.Equal(t, exp, body, name)
+	}
+
+	same("dists/stable/InRelease")
+	same("dists/stable/main/binary-amd64/Packages.xz")
+	same("pool/main/a/app/app_1.0_amd64.deb")
+
+	n := atomic.LoadInt32(&hits)
+
+	same("dists/stable/InRelease")
+	same("dists/stable/main/binary-amd64/Packages.xz")
+	same("pool/main/a/app/app_1.0_amd64.deb")
+
+	assert.Equal(t, n, atomic.LoadInt32(&hits), "served from cache")
+
+	code, _ := get("pool/main/a/app/app_3.0_amd64.deb")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, _ = get("../upstream/db/suites.json")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// tampered upstream file is rejected
+	err = ioutil.WriteFile(filepath.Join(up.Path, "pool/main/a/app/app_2.0_amd64.deb"), []byte("evil"), 0644)
+	require.NoError(t, err)
+
+	code, _ = get("pool/main/a/app/app_2.0_amd64.deb")
+	assert.Equal(t, http.StatusBadGateway, code)
+
+	_, err = os.Stat(filepath.Join(p.Dir, "pool/main/a/app/app_2.0_amd64.deb"))
+	assert.True(t, os.IsNotExist(err), "%v", err)
+
+	// pool checksums are recovered from cache after restart
+	p = NewProxy(context.Background(), srv.URL, p.Dir)
+
+	code, _ = get("pool/main/a/app/app_1.0_amd64.deb")
+	assert.Equal(t, http.StatusOK, code)
+
+	code, body := get("pool/main/a/app/app_2.0_amd64.deb")
+	assert.Equal(t, http.StatusBadGateway, code, "%s", body)
+}
+
+func TestProxyStalledUpstream(t *testing.T) {
+	root, err := ioutil.TempDir("", "limbo_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-stop:
+		case <-req.Context().Done():
+		}
+	}))
+	defer srv.Close()
+
+	p := NewProxy(context.Background(), srv.URL, root)
+	p.Client.Timeout = 100 * time.Millisecond
+
+	done := make(chan error, 1)
+
+	go func() {
+		_, err := p.File("dists/stable/Release")
+		done <- err
+	}()
+
+	select {
+	case err = <-done:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("proxy hangs on stalled upstream")
+	}
+}
@@ -0,0 +1,362 @@
+package limbo
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// keepGenerations is the number of published suite generations kept on disk.
+// Index files of all of them stay available by hash
+// for clients which fetched Release before the swap.
+const keepGenerations = 3
+
+// publish regenerates suite indexes for components and archs and the suite Release file.
+// New tree is built in a staging dir, synced and then dists/<suite> link is atomically switched to it.
+// Files not regenerated are hard linked from the current generation.
+// l.mu must be held.
+func (l *Limbo) publish(suite string, comps, archs []string) (err error) {
+	if l.closed {
+		return ErrClosed
+	}
+
+	defer l.metrics.published(suite, time.Now())
+
+	gdir := filepath.Join(l.Generations, suite)
+
+	cur, err := l.currentGeneration(suite)
+	if err != nil {
+		return errors.Wrap(err, "current generation")
+	}
+
+	gens, err := listGenerations(gdir)
+	if err != nil {
+		return errors.Wrap(err, "list generations")
+	}
+
+	tmp, err := ioutil.TempDir(gdir, ".staging.*")
+	if err != nil {
+		return errors.Wrap(err, "create staging dir")
+	}
+	defer func() {
+		if err != nil {
+			_ = os.RemoveAll(tmp)
+		}
+	}()
+
+	err = os.Chmod(tmp, 0755)
+	if err != nil {
+		return errors.Wrap(err, "chmod")
+	}
+
+	if cur != "" {
+		err = linkTree(cur, tmp)
+		if err != nil {
+			return errors.Wrap(err, "link current generation")
+		}
+	}
+
+	d := l.suites[suite]
+
+	err = l.writeIndexes(tmp, d, comps, archs)
+	if err != nil {
+		return errors.Wrap(err, "write indexes")
+	}
+
+	err = l.writeRelease(tmp, suite, d, time.Now(), l.Release.ValidFor)
+	if err != nil {
+		return errors.Wrap(err, "write release")
+	}
+
+	if l.Signer != nil {
+		err = l.signRelease(tmp)
+		if err != nil {
+			return errors.Wrap(err, "sign release")
+		}
+	}
+
+	var keep []string
+	if n := len(gens); n != 0 {
+		if n > keepGenerations-1 {
+			n = keepGenerations - 1
+		}
+
+		for _, g := range gens[len(gens)-n:] {
+			keep = append(keep, filepath.Join(gdir, strconv.Itoa(g)))
+		}
+	}
+
+	err = writeByHash(tmp, keep)
+	if err != nil {
+		return errors.Wrap(err, "write by-hash")
+	}
+
+	err = syncTree(tmp)
+	if err != nil {
+		return errors.Wrap(err, "sync")
+	}
+
+	next := 1
+	if len(gens) != 0 {
+		next = gens[len(gens)-1] + 1
+	}
+
+	dir := filepath.Join(gdir, strconv.Itoa(next))
+
+	err = os.Rename(tmp, dir)
+	if err != nil {
+		return errors.Wrap(err, "rename staging dir")
+	}
+
+	err = l.switchLink(suite, dir)
+	if err != nil {
+		return errors.Wrap(err, "switch link")
+	}
+
+	l.tr.Printw("published", "suite", suite, "generation", next)
+
+	gens = append(gens, next)
+
+	for len(gens) > keepGenerations {
+		err = os.RemoveAll(filepath.Join(gdir, strconv.Itoa(gens[0])))
+		if err != nil {
+			return errors.Wrap(err, "remove old generation")
+		}
+
+		gens = gens[1:]
+	}
+
+	return nil
+}
+
+// currentGeneration returns the dir dists/<suite> links to or empty string if not published yet.
+// Plain dists/<suite> dir of older versions is moved to generations.
+func (l *Limbo) currentGeneration(suite string) (string, error) {
+	link := filepath.Join(l.Dists, suite)
+	gdir := filepath.Join(l.Generations, suite)
+
+	err := os.MkdirAll(gdir, 0755)
+	if err != nil {
+		return "", errors.Wrap(err, "create generations dir")
+	}
+
+	inf, err := os.Lstat(link)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "stat")
+	}
+
+	if inf.Mode()&os.ModeSymlink != 0 {
+		dst, err := os.Readlink(link)
+		if err != nil {
+			return "", errors.Wrap(err, "read link")
+		}
+
+		if !filepath.IsAbs(dst) {
+			dst = filepath.Join(l.Dists, dst)
+		}
+
+		return dst, nil
+	}
+
+	if !inf.IsDir() {
+		return "", errors.Errorf("%v is not a dir", link)
+	}
+
+	dir := filepath.Join(gdir, "0")
+
+	err = os.Rename(link, dir)
+	if err != nil {
+		return "", errors.Wrap(err, "move legacy dists")
+	}
+
+	err = l.switchLink(suite, dir)
+	if err != nil {
+		return "", err
+	}
+
+	l.tr.Printw("moved legacy dists to generations", "suite", suite)
+
+	return dir, nil
+}
+
+// switchLink atomically points dists/<suite> to dir.
+func (l *Limbo) switchLink(suite, dir string) error {
+	err := os.MkdirAll(l.Dists, 0755)
+	if err != nil {
+		return errors.Wrap(err, "create dists dir")
+	}
+
+	dst, err := filepath.Rel(l.Dists, dir)
+	if err != nil {
+		dst = dir
+	}
+
+	tmp := filepath.Join(l.Dists, "."+suite+".link")
+
+	_ = os.Remove(tmp)
+
+	err = os.Symlink(dst, tmp)
+	if err != nil {
+		return errors.Wrap(err, "symlink")
+	}
+
+	err = os.Rename(tmp, filepath.Join(l.Dists, suite))
+	if err != nil {
+		_ = os.Remove(tmp)
+		return errors.Wrap(err, "rename")
+	}
+
+	return syncDir(l.Dists)
+}
+
+// listGenerations returns sorted generation numbers in dir.
+func listGenerations(dir string) (gens []int, err error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fi := range fis {
+		n, err := strconv.Atoi(fi.Name())
+		if err != nil || !fi.IsDir() {
+			continue
+		}
+
+		gens = append(gens, n)
+	}
+
+	sort.Ints(gens)
+
+	return gens, nil
+}
+
+// writeByHash links every index file listed in dir/Release to <index dir>/by-hash/SHA256/<sum>.
+// By-hash files not listed in dir/Release or any of keep Releases are removed.
+func writeByHash(dir string, keep []string) (err error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "Release"))
+	if err != nil {
+		return errors.Wrap(err, "read release")
+	}
+
+	files, err := parseReleaseSums(data)
+	if err != nil {
+		return errors.Wrap(err, "parse release")
+	}
+
+	sums := map[string]struct{}{}
+
+	for _, f := range files {
+		sums[f.SHA256Sum] = struct{}{}
+
+		bh := filepath.Join(dir, filepath.FromSlash(path.Dir(f.Name)), "by-hash", "SHA256")
+
+		err = os.MkdirAll(bh, 0755)
+		if err != nil {
+			return errors.Wrap(err, "create dir")
+		}
+
+		err = os.Link(filepath.Join(dir, filepath.FromSlash(f.Name)), filepath.Join(bh, f.SHA256Sum))
+		if err != nil && !os.IsExist(err) {
+			return errors.Wrapf(err, "link %v", f.Name)
+		}
+	}
+
+	for _, k := range keep {
+		data, err := ioutil.ReadFile(filepath.Join(k, "Release"))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return errors.Wrap(err, "read previous release")
+		}
+
+		files, err := parseReleaseSums(data)
+		if err != nil {
+			continue
+		}
+
+		for _, f := range files {
+			sums[f.SHA256Sum] = struct{}{}
+		}
+	}
+
+	return filepath.Walk(dir, func(p string, inf os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if inf.IsDir() || filepath.Base(filepath.Dir(filepath.Dir(p))) != "by-hash" {
+			return nil
+		}
+
+		if _, ok := sums[inf.Name()]; ok {
+			return nil
+		}
+
+		return os.Remove(p)
+	})
+}
+
+// linkTree recreates src dir tree in dst with files hard linked.
+// Release files are skipped as they are always regenerated.
+func linkTree(src, dst string) error {
+	return filepath.Walk(src, func(p string, inf os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+
+		if inf.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), 0755)
+		}
+
+		if releaseFiles[rel] {
+			return nil
+		}
+
+		return os.Link(p, filepath.Join(dst, rel))
+	})
+}
+
+// syncTree syncs every dir in the tree. Files are synced when written.
+func syncTree(root string) error {
+	return filepath.Walk(root, func(p string, inf os.FileInfo, err error) error {
+		if err != nil || !inf.IsDir() {
+			return err
+		}
+
+		return syncDir(p)
+	})
+}
+
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return errors.Wrap(err, "open dir")
+	}
+
+	err = f.Sync()
+
+	if e := f.Close(); err == nil {
+		err = e
+	}
+
+	if err != nil {
+		return errors.Wrapf(err, "sync %v", dir)
+	}
+
+	return nil
+}
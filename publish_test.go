@@ -0,0 +1,101 @@
+package limbo
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishGenerations(t *testing.T) {
+	root, err := ioutil.TempDir("", "limbo_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	l, err := New(context.Background(), root)
+	require.NoError(t, err)
+
+	// dists of older versions is a plain dir
+	err = os.MkdirAll(filepath.Join(l.Dists, "stable", "main", "binary-amd64"), 0755)
+	require.NoError(t, err)
+
+	err = ioutil.WriteFile(filepath.Join(l.Dists, "stable", "main", "binary-amd64", "Packages"), nil, 0644)
+	require.NoError(t, err)
+
+	err = l.UpdateIndex()
+	require.NoError(t, err)
+
+	link := filepath.Join(l.Dists, "stable")
+
+	inf, err := os.Lstat(link)
+	require.NoError(t, err)
+	assert.True(t, inf.Mode()&os.ModeSymlink != 0, "dists/stable is not a link")
+
+	rel, err := ioutil.ReadFile(filepath.Join(link, "Release"))
+	require.NoError(t, err)
+	assert.Contains(t, string(rel), "Acquire-By-Hash: yes\n")
+	assert.NotContains(t, string(rel), "by-hash")
+
+	var hashes []string
+
+	for i, v := range []string{"1.0", "2.0", "3.0", "4.0"} {
+		old, err := os.Readlink(link)
+		require.NoError(t, err)
+
+		_, err = l.Upload(bytes.NewReader(packageBytes(t, "app", v, "amd64")))
+		require.NoError(t, err)
+
+		cur, err := os.Readlink(link)
+		require.NoError(t, err)
+		assert.NotEqual(t, old, cur, "upload %d", i)
+
+		f, err := hashFile(filepath.Join(link, "main", "binary-amd64", "Packages"))
+		require.NoError(t, err)
+
+		hashes = append(hashes, f.SHA256Sum)
+
+		_, err = os.Stat(filepath.Join(link, "main", "binary-amd64", "by-hash", "SHA256", f.SHA256Sum))
+		assert.NoError(t, err, "upload %d", i)
+
+		// previous generation is still there and not changed
+		_, err = os.Stat(filepath.Join(l.Dists, old, "Release"))
+		assert.NoError(t, err, "upload %d", i)
+
+		idx, err := ioutil.ReadFile(filepath.Join(l.Dists, old, "main", "binary-amd64", "Packages"))
+		require.NoError(t, err)
+		assert.NotContains(t, string(idx), "Version: "+v+"\n", "upload %d", i)
+	}
+
+	gens, err := listGenerations(filepath.Join(l.Generations, "stable"))
+	require.NoError(t, err)
+	assert.Len(t, gens, keepGenerations)
+
+	byHash := filepath.Join(link, "main", "binary-amd64", "by-hash", "SHA256")
+
+	for i, h := range hashes {
+		_, err = os.Stat(filepath.Join(byHash, h))
+
+		if i >= len(hashes)-keepGenerations {
+			assert.NoError(t, err, "hash %d is pruned", i)
+		} else {
+			assert.True(t, os.IsNotExist(err), "hash %d is kept", i)
+		}
+	}
+
+	// old generations are not modified by the new ones
+	cur, err := os.Readlink(link)
+	require.NoError(t, err)
+
+	prev := filepath.Join(l.Generations, "stable", strconv.Itoa(gens[len(gens)-2]))
+	assert.NotEqual(t, filepath.Join(l.Dists, cur), prev)
+
+	idx, err := ioutil.ReadFile(filepath.Join(prev, "main", "binary-amd64", "Packages"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(idx), "Version: 4.0")
+}
@@ -0,0 +1,226 @@
+package limbo
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/rndcenter/limbo/textproto"
+)
+
+type (
+	// Release holds descriptive fields of the generated Release files.
+	Release struct {
+		Origin      string
+		Label       string
+		Codename    string
+		Description string
+
+		// ValidFor sets Valid-Until to Date + ValidFor if not zero.
+		ValidFor time.Duration
+	}
+
+	indexFile struct {
+		Name string
+		Size int64
+
+		MD5Sum    string
+		SHA1Sum   string
+		SHA256Sum string
+	}
+)
+
+var releaseFiles = map[string]bool{
+	"Release":     true,
+	"Release.gpg": true,
+	"InRelease":   true,
+}
+
+// writeRelease writes <dir>/Release of dist d listing every index file under dir.
+// Codename is set for the default suite only, other suites use their name.
+func (l *Limbo) writeRelease(dir, suite string, d dist, date time.Time, validFor time.Duration) (err error) {
+	files, err := hashIndexFiles(dir)
+	if err != nil {
+		return errors.Wrap(err, "hash index files")
+	}
+
+	var b bytes.Buffer
+
+	w := textproto.NewWriter(&b)
+
+	date = date.UTC()
+
+	codename := suite
+	if suite == l.Suite {
+		codename = l.Release.Codename
+	}
+
+	fields := [][2]string{
+		{"Origin", l.Release.Origin},
+		{"Label", l.Release.Label},
+		{"Suite", suite},
+		{"Codename", codename},
+		{"Description", l.Release.Description},
+		{"Date", date.Format(time.RFC1123)},
+	}
+
+	if validFor != 0 {
+		fields = append(fields, [2]string{"Valid-Until", date.Add(validFor).Format(time.RFC1123)})
+	}
+
+	fields = append(fields, [][2]string{
+		{"Architectures", strings.Join(releaseArchs(l.distArchs(d)), " ")},
+		{"Components", strings.Join(d.components(), " ")},
+		{"No-Support-for-Architecture-all", "Packages"},
+		{"Acquire-By-Hash", "yes"},
+		{"MD5Sum", hashList(files, func(f *indexFile) string { return f.MD5Sum })},
+		{"SHA1", hashList(files, func(f *indexFile) string { return f.SHA1Sum })},
+		{"SHA256", hashList(files, func(f *indexFile) string { return f.SHA256Sum })},
+	}...)
+
+	for _, kv := range fields {
+		if kv[1] == "" {
+			continue
+		}
+
+		err = w.PairStrings(kv[0], kv[1])
+		if err != nil {
+			return errors.Wrapf(err, "write %v", kv[0])
+		}
+	}
+
+	l.tr.Printw("write release", "dir", dir, "files", len(files))
+
+	err = writeFileAtomic(filepath.Join(dir, "Release"), b.Bytes(), 0644)
+	if err != nil {
+		return errors.Wrap(err, "write file")
+	}
+
+	return nil
+}
+
+func hashIndexFiles(dir string) (files []*indexFile, err error) {
+	err = filepath.Walk(dir, func(path string, inf os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if inf.IsDir() && inf.Name() == "by-hash" {
+			return filepath.SkipDir
+		}
+
+		if inf.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		if releaseFiles[rel] {
+			return nil
+		}
+
+		f, err := hashFile(path)
+		if err != nil {
+			return errors.Wrapf(err, "hash %v", rel)
+		}
+
+		f.Name = filepath.ToSlash(rel)
+
+		files = append(files, f)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+
+	return files, nil
+}
+
+func hashFile(path string) (_ *indexFile, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "open")
+	}
+	defer func() {
+		e := f.Close()
+		if err == nil {
+			err = errors.Wrap(e, "close")
+		}
+	}()
+
+	return hashReader(f)
+}
+
+func hashReader(r io.Reader) (*indexFile, error) {
+	md5h := md5.New()
+	sha1h := sha1.New()
+	sha256h := sha256.New()
+
+	n, err := io.Copy(io.MultiWriter(md5h, sha1h, sha256h), r)
+	if err != nil {
+		return nil, errors.Wrap(err, "read")
+	}
+
+	sum := func(h hash.Hash) string {
+		return hex.EncodeToString(h.Sum(nil))
+	}
+
+	return &indexFile{
+		Size:      n,
+		MD5Sum:    sum(md5h),
+		SHA1Sum:   sum(sha1h),
+		SHA256Sum: sum(sha256h),
+	}, nil
+}
+
+func hashList(files []*indexFile, sum func(f *indexFile) string) string {
+	var b strings.Builder
+
+	for _, f := range files {
+		fmt.Fprintf(&b, "\n%s %16d %s", sum(f), f.Size, f.Name)
+	}
+
+	return b.String()
+}
+
+// releaseArchs returns architectures for the Release file.
+// all is omitted since its packages are listed in every binary-<arch> index.
+func releaseArchs(archs []string) []string {
+	var res []string
+
+	for _, a := range archs {
+		if a == ArchAll {
+			continue
+		}
+
+		res = append(res, a)
+	}
+
+	if len(res) == 0 {
+		res = append(res, ArchAll)
+	}
+
+	sort.Strings(res)
+
+	return res
+}
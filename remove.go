@@ -0,0 +1,132 @@
+package limbo
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Remove removes package name of version and arch from suite, default suite if empty.
+// If purge is set it's removed from all the suites and its pool file is deleted.
+// A file referenced by a snapshot is not purged.
+// Only indexes of the affected components and architectures are regenerated.
+func (l *Limbo) Remove(name, version, arch, suite string, purge bool) (removed []*PackageRef, err error) {
+	if suite == "" {
+		suite = l.Suite
+	}
+
+	err = l.lock()
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		l.unlock(err)
+	}()
+
+	var snapd fileSet
+
+	if purge {
+		snapd, err = l.snapshotRefs()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	d, ok := l.suites[suite]
+	if !ok {
+		return nil, &PackageError{Reason: ReasonNotFound, Err: errors.Errorf("no suite %v", suite)}
+	}
+
+	match := func(p *Package) bool {
+		return p != nil && p.Control.Package == name && p.Control.Version == version && p.Control.Architecture == arch
+	}
+
+	files := fileSet{}
+
+	for _, cn := range d.components() {
+		for fn := range d[cn] {
+			if match(l.pkgs[fn]) {
+				files[fn] = struct{}{}
+			}
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, &PackageError{Reason: ReasonNotFound, Err: errors.Errorf("%v %v %v not found in %v", name, version, arch, suite)}
+	}
+
+	for fn := range files {
+		if _, ok := snapd[fn]; ok {
+			return nil, &PackageError{Reason: ReasonConflict, Err: errors.Errorf("%v is referenced by a snapshot", fn)}
+		}
+	}
+
+	comps := map[string]map[string]struct{}{}
+
+	for _, sn := range l.suiteNames() {
+		if sn != suite && !purge {
+			continue
+		}
+
+		for cn, set := range l.suites[sn] {
+			for fn := range files {
+				if _, ok := set[fn]; !ok {
+					continue
+				}
+
+				delete(set, fn)
+
+				if comps[sn] == nil {
+					comps[sn] = map[string]struct{}{}
+				}
+
+				comps[sn][cn] = struct{}{}
+
+				removed = append(removed, &PackageRef{Suite: sn, Component: cn, Package: l.pkgs[fn]})
+			}
+		}
+	}
+
+	for fn := range files {
+		l.orphan(fn)
+
+		if !purge {
+			continue
+		}
+
+		err = l.removePoolFile(fn)
+		if err != nil {
+			return nil, errors.Wrapf(err, "remove %v", fn)
+		}
+
+		delete(l.pkgs, fn)
+		delete(l.cache, fn)
+		delete(l.orphans, fn)
+	}
+
+	l.tr.Printw("remove", "package", name, "version", version, "arch", arch, "suite", suite, "purge", purge, "refs", len(removed))
+
+	if purge {
+		err = l.saveCache(l.cache)
+		if err != nil {
+			return nil, errors.Wrap(err, "save pool cache")
+		}
+	}
+
+	err = l.saveSuites()
+	if err != nil {
+		return nil, errors.Wrap(err, "save suites")
+	}
+
+	for _, sn := range l.suiteNames() {
+		if comps[sn] == nil {
+			continue
+		}
+
+		err = l.publish(sn, setList(comps[sn]), l.affectedArchs(sn, arch))
+		if err != nil {
+			return nil, errors.Wrapf(err, "publish %v", sn)
+		}
+	}
+
+	return removed, nil
+}
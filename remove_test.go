@@ -0,0 +1,69 @@
+package limbo
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemove(t *testing.T) {
+	l := newTestLimbo(t)
+
+	for _, v := range []string{"1.0", "2.0"} {
+		for _, s := range []string{"stable", "staging"} {
+			_, err := l.UploadTo(bytes.NewReader(packageBytes(t, "app", v, "amd64")), s, "")
+			require.NoError(t, err)
+		}
+	}
+
+	index := func(suite string) string {
+		data, err := ioutil.ReadFile(filepath.Join(l.Dists, suite, "main", "binary-amd64", "Packages"))
+		require.NoError(t, err)
+
+		return string(data)
+	}
+
+	removed, err := l.Remove("app", "2.0", "amd64", "stable", false)
+	require.NoError(t, err)
+	require.Len(t, removed, 1)
+
+	assert.NotContains(t, index("stable"), "Version: 2.0\n")
+	assert.Contains(t, index("staging"), "Version: 2.0\n")
+
+	_, err = os.Stat(filepath.Join(l.Path, "pool/main/a/app/app_2.0_amd64.deb"))
+	assert.NoError(t, err)
+
+	var pe *PackageError
+
+	_, err = l.Remove("app", "2.0", "amd64", "stable", false)
+	if assert.True(t, errors.As(err, &pe), "%v", err) {
+		assert.Equal(t, ReasonNotFound, pe.Reason)
+	}
+
+	_, err = l.CreateSnapshot("staging", "s1")
+	require.NoError(t, err)
+
+	_, err = l.Remove("app", "2.0", "amd64", "staging", true)
+	if assert.True(t, errors.As(err, &pe), "%v", err) {
+		assert.Equal(t, ReasonConflict, pe.Reason)
+	}
+
+	err = os.RemoveAll(filepath.Join(l.DB, "snapshots"))
+	require.NoError(t, err)
+
+	removed, err = l.Remove("app", "1.0", "amd64", "staging", true)
+	require.NoError(t, err)
+	assert.Len(t, removed, 2)
+
+	assert.NotContains(t, index("stable"), "Version: 1.0\n")
+	assert.NotContains(t, index("staging"), "Version: 1.0\n")
+
+	_, err = os.Stat(filepath.Join(l.Path, "pool/main/a/app/app_1.0_amd64.deb"))
+	assert.True(t, os.IsNotExist(err), "%v", err)
+}
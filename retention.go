@@ -0,0 +1,360 @@
+package limbo
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/rndcenter/limbo/deb"
+)
+
+type (
+	// RetentionRule limits versions of packages kept in a suite.
+	// A version is kept if any of the set limits keeps it.
+	// Rule with no limits keeps everything.
+	RetentionRule struct {
+		Suite   string // suite name, any if empty or "*"
+		Package string // package name glob pattern, any if empty
+
+		KeepLast      int           // keep N highest versions
+		KeepNewerThan time.Duration // keep versions uploaded in this period
+	}
+
+	// GCReport is what garbage collection did or would do in dry-run mode.
+	GCReport struct {
+		Dropped []*DroppedRef // suite references removed by retention
+		Deleted []string      // pool files removed
+		Size    int64         // bytes freed
+	}
+
+	// DroppedRef is a package removed from a suite component.
+	DroppedRef struct {
+		Suite     string
+		Component string
+		Filename  string
+	}
+)
+
+// ParseRetention parses space or semicolon separated retention rules.
+// Rule format is suite:package:limits, where limits is a comma separated list of
+// last=N and days=D.
+//
+//	stable:*:last=5 staging:lib*:last=2,days=14
+func ParseRetention(s string) (rules []RetentionRule, err error) {
+	for _, rs := range strings.FieldsFunc(s, func(r rune) bool { return r == ';' || r == ' ' || r == '\t' || r == '\n' }) {
+		p := strings.Split(rs, ":")
+		if len(p) != 3 {
+			return nil, errors.Errorf("bad rule %q: expected suite:package:limits", rs)
+		}
+
+		r := RetentionRule{
+			Suite:   p[0],
+			Package: p[1],
+		}
+
+		if _, err = path.Match(r.Package, ""); err != nil {
+			return nil, errors.Wrapf(err, "bad rule %q: package pattern", rs)
+		}
+
+		for _, lim := range strings.Split(p[2], ",") {
+			kv := strings.SplitN(lim, "=", 2)
+			if len(kv) != 2 {
+				return nil, errors.Errorf("bad rule %q: bad limit %q", rs, lim)
+			}
+
+			n, err := strconv.Atoi(kv[1])
+			if err != nil || n < 0 {
+				return nil, errors.Errorf("bad rule %q: bad limit %q", rs, lim)
+			}
+
+			switch kv[0] {
+			case "last":
+				r.KeepLast = n
+			case "days":
+				r.KeepNewerThan = time.Duration(n) * 24 * time.Hour
+			default:
+				return nil, errors.Errorf("bad rule %q: unknown limit %q", rs, kv[0])
+			}
+		}
+
+		rules = append(rules, r)
+	}
+
+	return rules, nil
+}
+
+func (r *RetentionRule) match(suite, pkg string) bool {
+	if r.Suite != "" && r.Suite != "*" && r.Suite != suite {
+		return false
+	}
+
+	if r.Package == "" {
+		return true
+	}
+
+	ok, _ := path.Match(r.Package, pkg)
+
+	return ok
+}
+
+// rule returns the first rule matching suite and package or nil.
+func (l *Limbo) rule(suite, pkg string) *RetentionRule {
+	for i := range l.Retention {
+		if r := &l.Retention[i]; r.match(suite, pkg) {
+			return r
+		}
+	}
+
+	return nil
+}
+
+// GC applies retention rules and deletes pool files no suite or snapshot references.
+// Nothing is changed if dryRun is set, the report tells what would be done.
+func (l *Limbo) GC(dryRun bool) (rep *GCReport, err error) {
+	err = l.lock()
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if dryRun {
+			l.release()
+		} else {
+			l.unlock(err)
+		}
+	}()
+
+	snapd, err := l.snapshotRefs()
+	if err != nil {
+		return nil, err
+	}
+
+	rep = &GCReport{}
+
+	now := time.Now()
+
+	for _, s := range l.suiteNames() {
+		rep.Dropped = append(rep.Dropped, l.retain(s, now)...)
+	}
+
+	drop := map[string]fileSet{} // suite/component -> Filenames
+	for _, d := range rep.Dropped {
+		k := d.Suite + "/" + d.Component
+
+		if drop[k] == nil {
+			drop[k] = fileSet{}
+		}
+
+		drop[k][d.Filename] = struct{}{}
+	}
+
+	refd := fileSet{}
+
+	for sn, d := range l.suites {
+		for cn, set := range d {
+			for fn := range set {
+				if _, ok := drop[sn+"/"+cn][fn]; !ok {
+					refd[fn] = struct{}{}
+				}
+			}
+		}
+	}
+
+	var orphans []string
+
+	for fn, p := range l.pkgs {
+		if _, ok := refd[fn]; ok {
+			continue
+		}
+
+		orphans = append(orphans, fn)
+
+		if _, ok := snapd[fn]; ok {
+			continue
+		}
+
+		rep.Deleted = append(rep.Deleted, fn)
+		rep.Size += p.Size
+	}
+
+	sort.Strings(rep.Deleted)
+
+	l.tr.Printw("gc", "dropped", len(rep.Dropped), "deleted", len(rep.Deleted), "size", rep.Size, "dry_run", dryRun)
+
+	if dryRun || len(rep.Dropped) == 0 && len(rep.Deleted) == 0 {
+		return rep, nil
+	}
+
+	comps := map[string]map[string]struct{}{}
+	archs := map[string][]string{}
+
+	for _, d := range rep.Dropped {
+		delete(l.suites[d.Suite][d.Component], d.Filename)
+
+		if comps[d.Suite] == nil {
+			comps[d.Suite] = map[string]struct{}{}
+		}
+
+		comps[d.Suite][d.Component] = struct{}{}
+		archs[d.Suite] = append(archs[d.Suite], l.pkgs[d.Filename].Control.Architecture)
+	}
+
+	if l.orphans == nil {
+		l.orphans = fileSet{}
+	}
+
+	for _, fn := range orphans {
+		l.orphans[fn] = struct{}{}
+	}
+
+	for _, fn := range rep.Deleted {
+		err = l.removePoolFile(fn)
+		if err != nil {
+			return rep, errors.Wrapf(err, "remove %v", fn)
+		}
+
+		delete(l.pkgs, fn)
+		delete(l.cache, fn)
+		delete(l.orphans, fn)
+	}
+
+	err = l.saveCache(l.cache)
+	if err != nil {
+		return rep, errors.Wrap(err, "save pool cache")
+	}
+
+	err = l.saveSuites()
+	if err != nil {
+		return rep, errors.Wrap(err, "save suites")
+	}
+
+	for _, s := range l.suiteNames() {
+		if comps[s] == nil {
+			continue
+		}
+
+		var al []string
+
+		for _, a := range archs[s] {
+			al = append(al, l.affectedArchs(s, a)...)
+		}
+
+		err = l.publish(s, setList(comps[s]), uniq(al))
+		if err != nil {
+			return rep, errors.Wrapf(err, "publish %v", s)
+		}
+	}
+
+	return rep, nil
+}
+
+// retain returns suite references retention rules drop. l.mu must be held.
+func (l *Limbo) retain(suite string, now time.Time) (drop []*DroppedRef) {
+	type ref struct {
+		comp string
+		p    *Package
+	}
+
+	byName := map[string][]ref{}
+
+	d := l.suites[suite]
+
+	for _, cn := range d.components() {
+		for _, fn := range d[cn].list() {
+			p := l.pkgs[fn]
+			if p == nil {
+				continue
+			}
+
+			byName[p.Control.Package] = append(byName[p.Control.Package], ref{comp: cn, p: p})
+		}
+	}
+
+	names := make([]string, 0, len(byName))
+	for n := range byName {
+		names = append(names, n)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		r := l.rule(suite, name)
+		if r == nil || r.KeepLast == 0 && r.KeepNewerThan == 0 {
+			continue
+		}
+
+		refs := byName[name]
+
+		// version -> newest file mtime
+		uploaded := map[string]time.Time{}
+
+		for _, rf := range refs {
+			var mt time.Time
+			if e, ok := l.cache[rf.p.Filename]; ok {
+				mt = e.ModTime
+			}
+
+			if t, ok := uploaded[rf.p.Control.Version]; !ok || mt.After(t) {
+				uploaded[rf.p.Control.Version] = mt
+			}
+		}
+
+		vers := make([]string, 0, len(uploaded))
+		for v := range uploaded {
+			vers = append(vers, v)
+		}
+
+		sort.Slice(vers, func(i, j int) bool {
+			if c, err := deb.CompareVersions(vers[i], vers[j]); err == nil && c != 0 {
+				return c > 0
+			}
+
+			return vers[i] > vers[j]
+		})
+
+		keep := map[string]bool{}
+
+		for i, v := range vers {
+			keep[v] = r.KeepLast != 0 && i < r.KeepLast ||
+				r.KeepNewerThan != 0 && now.Sub(uploaded[v]) < r.KeepNewerThan
+		}
+
+		for _, rf := range refs {
+			if keep[rf.p.Control.Version] {
+				continue
+			}
+
+			drop = append(drop, &DroppedRef{
+				Suite:     suite,
+				Component: rf.comp,
+				Filename:  rf.p.Filename,
+			})
+		}
+	}
+
+	return drop
+}
+
+// removePoolFile removes file and its parent dirs left empty.
+func (l *Limbo) removePoolFile(fn string) error {
+	p := filepath.Join(l.Path, filepath.FromSlash(fn))
+
+	err := os.Remove(p)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for dir := filepath.Dir(p); dir != l.Pool && strings.HasPrefix(dir, l.Pool); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+
+	return nil
+}
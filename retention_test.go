@@ -0,0 +1,92 @@
+package limbo
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRetention(t *testing.T) {
+	rules, err := ParseRetention("stable:*:last=5 staging:lib*:last=2,days=14")
+	require.NoError(t, err)
+
+	assert.Equal(t, []RetentionRule{
+		{Suite: "stable", Package: "*", KeepLast: 5},
+		{Suite: "staging", Package: "lib*", KeepLast: 2, KeepNewerThan: 14 * 24 * time.Hour},
+	}, rules)
+
+	for _, s := range []string{"stable:*", "stable:*:last", "stable:*:keep=1", "stable:[:last=1", "stable:*:last=-1"} {
+		_, err = ParseRetention(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestGC(t *testing.T) {
+	l := newTestLimbo(t)
+
+	l.Retention = []RetentionRule{{Suite: "stable", Package: "app", KeepLast: 1}}
+
+	for _, v := range []string{"1.0", "1.10", "1.9"} {
+		_, err := l.Upload(bytes.NewReader(packageBytes(t, "app", v, "amd64")))
+		require.NoError(t, err)
+
+		if v == "1.0" {
+			_, err = l.CreateSnapshot("", "s1")
+			require.NoError(t, err)
+		}
+	}
+
+	_, err := l.Upload(bytes.NewReader(packageBytes(t, "other", "0.1", "amd64")))
+	require.NoError(t, err)
+
+	rep, err := l.GC(true)
+	require.NoError(t, err)
+
+	assert.Len(t, rep.Dropped, 2)
+	assert.Equal(t, []string{"pool/main/a/app/app_1.9_amd64.deb"}, rep.Deleted)
+
+	_, err = os.Stat(filepath.Join(l.Path, "pool/main/a/app/app_1.9_amd64.deb"))
+	require.NoError(t, err, "dry run")
+
+	rep, err = l.GC(false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"pool/main/a/app/app_1.9_amd64.deb"}, rep.Deleted)
+
+	_, err = os.Stat(filepath.Join(l.Path, "pool/main/a/app/app_1.9_amd64.deb"))
+	assert.True(t, os.IsNotExist(err), "%v", err)
+
+	_, err = os.Stat(filepath.Join(l.Path, "pool/main/a/app/app_1.0_amd64.deb"))
+	assert.NoError(t, err, "snapshot file")
+
+	index := func() string {
+		data, err := ioutil.ReadFile(filepath.Join(l.Dists, "stable", "main", "binary-amd64", "Packages"))
+		require.NoError(t, err)
+
+		return string(data)
+	}
+
+	assert.Contains(t, index(), "Version: 1.10\n")
+	assert.NotContains(t, index(), "Version: 1.0\n")
+	assert.Contains(t, index(), "Package: other\n")
+
+	// dropped file is not assigned back on reload
+	l, err = New(context.Background(), l.Path)
+	require.NoError(t, err)
+
+	err = l.UpdateIndex()
+	require.NoError(t, err)
+
+	assert.NotContains(t, index(), "Version: 1.0\n")
+
+	rep, err = l.GC(false)
+	require.NoError(t, err)
+	assert.Empty(t, rep.Dropped)
+	assert.Empty(t, rep.Deleted)
+}
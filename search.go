@@ -0,0 +1,93 @@
+package limbo
+
+import (
+	"path"
+	"sort"
+)
+
+type (
+	// Query selects published packages. Empty fields match anything.
+	Query struct {
+		Name      string // glob pattern
+		Version   string
+		Arch      string
+		Suite     string
+		Component string
+	}
+
+	// PackageRef is a package published in a suite component.
+	PackageRef struct {
+		Suite     string
+		Component string
+
+		*Package
+	}
+)
+
+// Search returns published packages matching q
+// sorted by name, version, architecture, suite and component.
+// Changes made by other processes are taken into account.
+func (l *Limbo) Search(q Query) (res []*PackageRef, err error) {
+	err = l.lock()
+	if err != nil {
+		return nil, err
+	}
+
+	defer l.release()
+
+	for _, sn := range l.suiteNames() {
+		if q.Suite != "" && q.Suite != sn {
+			continue
+		}
+
+		d := l.suites[sn]
+
+		for _, cn := range d.components() {
+			if q.Component != "" && q.Component != cn {
+				continue
+			}
+
+			for fn := range d[cn] {
+				p := l.pkgs[fn]
+				if p == nil || !q.match(p) {
+					continue
+				}
+
+				res = append(res, &PackageRef{
+					Suite:     sn,
+					Component: cn,
+					Package:   p,
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+
+		if a.Package != b.Package {
+			return packageLess(a.Package, b.Package)
+		}
+
+		if a.Suite != b.Suite {
+			return a.Suite < b.Suite
+		}
+
+		return a.Component < b.Component
+	})
+
+	return res, nil
+}
+
+func (q *Query) match(p *Package) bool {
+	c := &p.Control
+
+	if q.Name != "" {
+		if ok, _ := path.Match(q.Name, c.Package); !ok {
+			return false
+		}
+	}
+
+	return (q.Version == "" || q.Version == c.Version) &&
+		(q.Arch == "" || q.Arch == c.Architecture)
+}
@@ -0,0 +1,69 @@
+package limbo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearch(t *testing.T) {
+	l := newTestLimbo(t)
+
+	for _, p := range [][4]string{
+		{"libfoo", "1.10", "amd64", "stable"},
+		{"libfoo", "1.9", "amd64", "stable"},
+		{"libfoo", "1.10", "arm64", "staging"},
+		{"app", "1.0", "amd64", "stable"},
+	} {
+		_, err := l.UploadTo(bytes.NewReader(packageBytes(t, p[0], p[1], p[2])), p[3], "")
+		require.NoError(t, err)
+	}
+
+	res, err := l.Search(Query{Name: "libfoo"})
+	require.NoError(t, err)
+
+	var got []string
+	for _, r := range res {
+		got = append(got, r.Control.Version+" "+r.Control.Architecture+" "+r.Suite)
+	}
+
+	assert.Equal(t, []string{"1.9 amd64 stable", "1.10 amd64 stable", "1.10 arm64 staging"}, got)
+
+	res, err = l.Search(Query{Name: "lib*", Version: "1.10", Arch: "arm64", Suite: "staging"})
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+
+	assert.Equal(t, "main", res[0].Component)
+	assert.Equal(t, "pool/main/libf/libfoo/libfoo_1.10_arm64.deb", res[0].Filename)
+
+	data, err := json.Marshal(res[0])
+	require.NoError(t, err)
+
+	var m map[string]interface{}
+
+	err = json.Unmarshal(data, &m)
+	require.NoError(t, err)
+
+	assert.Equal(t, "staging", m["Suite"])
+	assert.Equal(t, res[0].SHA256Sum, m["SHA256"])
+	assert.Equal(t, "libfoo", m["Control"].(map[string]interface{})["Package"])
+
+	res, err = l.Search(Query{Name: "nope"})
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	// another process removes a package
+	o, err := New(context.Background(), l.Path)
+	require.NoError(t, err)
+
+	_, err = o.Remove("libfoo", "1.10", "arm64", "staging", false)
+	require.NoError(t, err)
+
+	res, err = l.Search(Query{Name: "libfoo"})
+	require.NoError(t, err)
+	assert.Len(t, res, 2)
+}
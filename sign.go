@@ -0,0 +1,156 @@
+package limbo
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/clearsign"
+)
+
+type (
+	// Signer signs Release files with an OpenPGP private key.
+	Signer struct {
+		e *openpgp.Entity
+	}
+)
+
+// LoadSigner reads an armored private key from file fn.
+// Passphrase is used to decrypt the key if it's encrypted.
+func LoadSigner(fn string, passphrase []byte) (_ *Signer, err error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, errors.Wrap(err, "open key file")
+	}
+	defer func() {
+		e := f.Close()
+		if err == nil {
+			err = errors.Wrap(e, "close key file")
+		}
+	}()
+
+	return ReadSigner(f, passphrase)
+}
+
+// ReadSigner reads an armored private key from r.
+func ReadSigner(r io.Reader, passphrase []byte) (*Signer, error) {
+	es, err := openpgp.ReadArmoredKeyRing(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "read key ring")
+	}
+
+	if len(es) == 0 {
+		return nil, errors.New("no keys found")
+	}
+
+	e := es[0]
+
+	if e.PrivateKey == nil {
+		return nil, errors.New("not a private key")
+	}
+
+	if e.PrivateKey.Encrypted {
+		if len(passphrase) == 0 {
+			return nil, errors.New("key is encrypted but no passphrase provided")
+		}
+
+		err = e.PrivateKey.Decrypt(passphrase)
+		if err != nil {
+			return nil, errors.Wrap(err, "decrypt key")
+		}
+	}
+
+	for _, sub := range e.Subkeys {
+		if sub.PrivateKey == nil || !sub.PrivateKey.Encrypted {
+			continue
+		}
+
+		err = sub.PrivateKey.Decrypt(passphrase)
+		if err != nil {
+			return nil, errors.Wrap(err, "decrypt subkey")
+		}
+	}
+
+	return &Signer{e: e}, nil
+}
+
+// KeyID returns the signing key id in hex.
+func (s *Signer) KeyID() string {
+	return s.e.PrimaryKey.KeyIdString()
+}
+
+// DetachSign writes armored detached signature of data to w (Release.gpg).
+func (s *Signer) DetachSign(w io.Writer, data []byte) error {
+	return openpgp.ArmoredDetachSign(w, s.e, bytes.NewReader(data), nil)
+}
+
+// ClearSign writes clearsigned data to w (InRelease).
+func (s *Signer) ClearSign(w io.Writer, data []byte) (err error) {
+	c, err := clearsign.Encode(w, s.e.PrivateKey, nil)
+	if err != nil {
+		return errors.Wrap(err, "encode")
+	}
+
+	_, err = c.Write(data)
+	if err != nil {
+		return errors.Wrap(err, "write")
+	}
+
+	return c.Close()
+}
+
+// WritePublicKey writes armored public key to w.
+func (s *Signer) WritePublicKey(w io.Writer) (err error) {
+	a, err := armor.Encode(w, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return errors.Wrap(err, "armor")
+	}
+
+	err = s.e.Serialize(a)
+	if err != nil {
+		return errors.Wrap(err, "serialize")
+	}
+
+	return a.Close()
+}
+
+// signRelease writes Release.gpg and InRelease next to the Release file in dir.
+func (l *Limbo) signRelease(dir string) (err error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "Release"))
+	if err != nil {
+		return errors.Wrap(err, "read release")
+	}
+
+	var b bytes.Buffer
+
+	err = l.Signer.DetachSign(&b, data)
+	if err != nil {
+		return errors.Wrap(err, "detach sign")
+	}
+
+	err = writeFileAtomic(filepath.Join(dir, "Release.gpg"), b.Bytes(), 0644)
+	if err != nil {
+		return errors.Wrap(err, "write Release.gpg")
+	}
+
+	b.Reset()
+
+	err = l.Signer.ClearSign(&b, data)
+	if err != nil {
+		return errors.Wrap(err, "clear sign")
+	}
+
+	err = writeFileAtomic(filepath.Join(dir, "InRelease"), b.Bytes(), 0644)
+	if err != nil {
+		return errors.Wrap(err, "write InRelease")
+	}
+
+	l.tr.Printw("release signed", "dir", dir, "key", l.Signer.KeyID())
+
+	return nil
+}
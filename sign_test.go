@@ -0,0 +1,114 @@
+package limbo
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/clearsign"
+)
+
+func TestSignRelease(t *testing.T) {
+	root, err := ioutil.TempDir("", "limbo_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	l, err := New(context.Background(), root)
+	require.NoError(t, err)
+
+	l.Signer = testSigner(t)
+
+	savePackage(t, l.Pool, "pkg", "1.0", "amd64")
+
+	err = l.UpdateIndex()
+	require.NoError(t, err)
+
+	var pub bytes.Buffer
+
+	err = l.Signer.WritePublicKey(&pub)
+	require.NoError(t, err)
+
+	keyring, err := openpgp.ReadArmoredKeyRing(&pub)
+	require.NoError(t, err)
+
+	dir := filepath.Join(l.Dists, l.Suite)
+
+	rel, err := ioutil.ReadFile(filepath.Join(dir, "Release"))
+	require.NoError(t, err)
+
+	sig, err := os.Open(filepath.Join(dir, "Release.gpg"))
+	require.NoError(t, err)
+	defer sig.Close()
+
+	_, err = openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(rel), sig)
+	assert.NoError(t, err)
+
+	inrel, err := ioutil.ReadFile(filepath.Join(dir, "InRelease"))
+	require.NoError(t, err)
+
+	b, _ := clearsign.Decode(inrel)
+	require.NotNil(t, b)
+
+	_, err = openpgp.CheckDetachedSignature(keyring, bytes.NewReader(b.Bytes), b.ArmoredSignature.Body)
+	assert.NoError(t, err)
+}
+
+func TestLoadSignerPassphrase(t *testing.T) {
+	const fn = "testdata/encrypted_key.asc"
+
+	_, err := LoadSigner(fn, nil)
+	assert.Error(t, err, "no passphrase")
+
+	_, err = LoadSigner(fn, []byte("wrong"))
+	assert.Error(t, err, "wrong passphrase")
+
+	s, err := LoadSigner(fn, []byte("secret"))
+	require.NoError(t, err)
+
+	var b bytes.Buffer
+
+	err = s.DetachSign(&b, []byte("data"))
+	require.NoError(t, err)
+
+	var pub bytes.Buffer
+
+	err = s.WritePublicKey(&pub)
+	require.NoError(t, err)
+
+	keyring, err := openpgp.ReadArmoredKeyRing(&pub)
+	require.NoError(t, err)
+
+	_, err = openpgp.CheckArmoredDetachedSignature(keyring, strings.NewReader("data"), &b)
+	assert.NoError(t, err)
+}
+
+func testSigner(t testing.TB) *Signer {
+	t.Helper()
+
+	e, err := openpgp.NewEntity("limbo test", "", "limbo@example.com", nil)
+	require.NoError(t, err)
+
+	var b bytes.Buffer
+
+	a, err := armor.Encode(&b, openpgp.PrivateKeyType, nil)
+	require.NoError(t, err)
+
+	err = e.SerializePrivate(a, nil)
+	require.NoError(t, err)
+
+	err = a.Close()
+	require.NoError(t, err)
+
+	s, err := ReadSigner(&b, nil)
+	require.NoError(t, err)
+
+	return s
+}
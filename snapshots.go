@@ -0,0 +1,223 @@
+package limbo
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	// Snapshot is a frozen state of a suite.
+	// Its indexes are served from snapshots/<name>/dists/<suite>/,
+	// pool files are shared with the live suites.
+	Snapshot struct {
+		Name  string
+		Suite string
+		Date  time.Time
+
+		Components map[string][]string // component -> Filenames
+	}
+)
+
+const snapshotVersion = 1
+
+func (l *Limbo) snapshotFile(name string) string {
+	return filepath.Join(l.DB, "snapshots", name+".json")
+}
+
+// CreateSnapshot freezes the current state of suite into a read-only snapshot name.
+// Indexes are written once and never updated, Release has no Valid-Until.
+func (l *Limbo) CreateSnapshot(suite, name string) (s *Snapshot, err error) {
+	if suite == "" {
+		suite = l.Suite
+	}
+
+	for _, f := range []struct{ name, val string }{
+		{"suite", suite},
+		{"snapshot name", name},
+	} {
+		if !validName(f.val, nameChars) {
+			return nil, &PackageError{Reason: ReasonMalformed, Err: errors.Errorf("bad %v: %q", f.name, f.val)}
+		}
+	}
+
+	err = l.lock()
+	if err != nil {
+		return nil, err
+	}
+
+	// snapshots are read from disk, suites are not changed
+	defer l.release()
+
+	src, ok := l.suites[suite]
+	if !ok {
+		return nil, &PackageError{Reason: ReasonNotFound, Err: errors.Errorf("no suite %v", suite)}
+	}
+
+	dst := filepath.Join(l.Snapshots, name)
+
+	_, err = os.Stat(dst)
+	if err == nil {
+		return nil, &PackageError{Reason: ReasonConflict, Err: errors.Errorf("snapshot %v exists", name)}
+	}
+	if !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "stat snapshot")
+	}
+
+	d := src.copy()
+
+	s = &Snapshot{
+		Name:       name,
+		Suite:      suite,
+		Date:       time.Now().UTC().Truncate(time.Second),
+		Components: map[string][]string{},
+	}
+
+	for c, set := range d {
+		s.Components[c] = set.list()
+	}
+
+	err = os.MkdirAll(l.Snapshots, 0755)
+	if err != nil {
+		return nil, errors.Wrap(err, "create snapshots dir")
+	}
+
+	tmp, err := ioutil.TempDir(l.Snapshots, "."+name+".*.tmp")
+	if err != nil {
+		return nil, errors.Wrap(err, "create temp dir")
+	}
+	defer func() {
+		if err != nil {
+			_ = os.RemoveAll(tmp)
+		}
+	}()
+
+	dir := filepath.Join(tmp, "dists", suite)
+
+	err = l.writeIndexes(dir, d, d.components(), l.distArchs(d))
+	if err != nil {
+		return nil, errors.Wrap(err, "write indexes")
+	}
+
+	err = l.writeRelease(dir, suite, d, s.Date, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "write release")
+	}
+
+	if l.Signer != nil {
+		err = l.signRelease(dir)
+		if err != nil {
+			return nil, errors.Wrap(err, "sign release")
+		}
+	}
+
+	err = writeByHash(dir, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "write by-hash")
+	}
+
+	data, err := json.MarshalIndent(struct {
+		Version int
+		*Snapshot
+	}{
+		Version:  snapshotVersion,
+		Snapshot: s,
+	}, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "encode")
+	}
+
+	err = os.Rename(tmp, dst)
+	if err != nil {
+		return nil, errors.Wrap(err, "move snapshot")
+	}
+
+	// the record makes snapshot listed and its files protected from gc, so it goes last
+	err = writeFileAtomic(l.snapshotFile(name), data, 0644)
+	if err != nil {
+		_ = os.RemoveAll(dst)
+
+		return nil, errors.Wrap(err, "save snapshot")
+	}
+
+	l.tr.Printw("snapshot created", "name", name, "suite", suite)
+
+	return s, nil
+}
+
+// snapshotRefs returns pool files referenced by snapshots.
+// l.mu must be held, so that no snapshot is created meanwhile.
+func (l *Limbo) snapshotRefs() (fileSet, error) {
+	snaps, err := l.ListSnapshots()
+	if err != nil {
+		return nil, errors.Wrap(err, "list snapshots")
+	}
+
+	refs := fileSet{}
+
+	for _, s := range snaps {
+		for _, files := range s.Components {
+			for _, fn := range files {
+				refs[fn] = struct{}{}
+			}
+		}
+	}
+
+	return refs, nil
+}
+
+// ListSnapshots returns all the snapshots sorted by name.
+func (l *Limbo) ListSnapshots() (ss []*Snapshot, err error) {
+	dir := filepath.Join(l.DB, "snapshots")
+
+	fis, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read dir")
+	}
+
+	for _, fi := range fis {
+		name := strings.TrimSuffix(fi.Name(), ".json")
+
+		if fi.IsDir() || name == fi.Name() || strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "read %v", name)
+		}
+
+		var f struct {
+			Version int
+			Snapshot
+		}
+
+		err = json.Unmarshal(data, &f)
+		if err != nil {
+			return nil, errors.Wrapf(err, "decode %v", name)
+		}
+
+		if f.Version != snapshotVersion {
+			return nil, errors.Errorf("snapshot %v: unsupported version: %v", name, f.Version)
+		}
+
+		s := f.Snapshot
+
+		ss = append(ss, &s)
+	}
+
+	sort.Slice(ss, func(i, j int) bool {
+		return ss[i].Name < ss[j].Name
+	})
+
+	return ss, nil
+}
@@ -0,0 +1,70 @@
+package limbo
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(t *testing.T) {
+	l := newTestLimbo(t)
+
+	l.Release.ValidFor = 7 * 24 * time.Hour
+
+	_, err := l.Upload(bytes.NewReader(packageBytes(t, "app", "1.0", "amd64")))
+	require.NoError(t, err)
+
+	s, err := l.CreateSnapshot("", "2026-10-17")
+	require.NoError(t, err)
+	assert.Equal(t, "stable", s.Suite)
+	assert.Equal(t, []string{"pool/main/a/app/app_1.0_amd64.deb"}, s.Components["main"])
+
+	_, err = l.Upload(bytes.NewReader(packageBytes(t, "app", "2.0", "amd64")))
+	require.NoError(t, err)
+
+	dir := filepath.Join(l.Snapshots, "2026-10-17", "dists", "stable")
+
+	idx, err := ioutil.ReadFile(filepath.Join(dir, "main", "binary-amd64", "Packages"))
+	require.NoError(t, err)
+	assert.Contains(t, string(idx), "Version: 1.0\n")
+	assert.NotContains(t, string(idx), "Version: 2.0\n")
+
+	rel, err := ioutil.ReadFile(filepath.Join(dir, "Release"))
+	require.NoError(t, err)
+	assert.Contains(t, string(rel), "Suite: stable\n")
+	assert.Contains(t, string(rel), " main/binary-amd64/Packages\n")
+	assert.NotContains(t, string(rel), "Valid-Until")
+
+	var pe *PackageError
+
+	_, err = l.CreateSnapshot("", "2026-10-17")
+	if assert.True(t, errors.As(err, &pe), "%v", err) {
+		assert.Equal(t, ReasonConflict, pe.Reason)
+	}
+
+	ss, err := l.ListSnapshots()
+	require.NoError(t, err)
+	if assert.Len(t, ss, 1) {
+		assert.Equal(t, s, ss[0])
+	}
+
+	// tree is removed if the record can't be saved
+	err = os.RemoveAll(filepath.Join(l.DB, "snapshots"))
+	require.NoError(t, err)
+
+	err = ioutil.WriteFile(filepath.Join(l.DB, "snapshots"), nil, 0644)
+	require.NoError(t, err)
+
+	_, err = l.CreateSnapshot("", "broken")
+	assert.Error(t, err)
+
+	_, err = os.Stat(filepath.Join(l.Snapshots, "broken"))
+	assert.True(t, os.IsNotExist(err), "%v", err)
+}
@@ -0,0 +1,384 @@
+package limbo
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	fileSet map[string]struct{}
+
+	// dist is a suite: pool files referenced by each of its components.
+	// Files are shared between suites, promotion moves references only.
+	dist map[string]fileSet // component -> Filenames
+)
+
+const suitesVersion = 1
+
+func (l *Limbo) suitesFile() string {
+	return filepath.Join(l.DB, "suites.json")
+}
+
+// loadSuites loads suites references and orphans:
+// known pool files dropped from all the suites.
+func (l *Limbo) loadSuites() (s map[string]dist, orphans fileSet, err error) {
+	s = map[string]dist{}
+	orphans = fileSet{}
+
+	data, err := ioutil.ReadFile(l.suitesFile())
+	if os.IsNotExist(err) {
+		return s, orphans, nil
+	}
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "read")
+	}
+
+	var f struct {
+		Version int
+		Suites  map[string]map[string][]string
+		Orphans []string
+	}
+
+	err = json.Unmarshal(data, &f)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "decode")
+	}
+
+	if f.Version != suitesVersion {
+		return nil, nil, errors.Errorf("unsupported suites version: %v", f.Version)
+	}
+
+	for _, fn := range f.Orphans {
+		orphans[fn] = struct{}{}
+	}
+
+	for sn, comps := range f.Suites {
+		d := dist{}
+
+		for cn, files := range comps {
+			set := fileSet{}
+
+			for _, fn := range files {
+				set[fn] = struct{}{}
+			}
+
+			d[cn] = set
+		}
+
+		s[sn] = d
+	}
+
+	return s, orphans, nil
+}
+
+// saveSuites persists suites references. l.mu must be held.
+func (l *Limbo) saveSuites() (err error) {
+	out := map[string]map[string][]string{}
+
+	for sn, d := range l.suites {
+		comps := map[string][]string{}
+
+		for cn, set := range d {
+			comps[cn] = set.list()
+		}
+
+		out[sn] = comps
+	}
+
+	data, err := json.MarshalIndent(struct {
+		Version int
+		Suites  map[string]map[string][]string
+		Orphans []string `json:",omitempty"`
+	}{
+		Version: suitesVersion,
+		Suites:  out,
+		Orphans: l.orphans.list(),
+	}, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encode")
+	}
+
+	return writeFileAtomic(l.suitesFile(), data, 0644)
+}
+
+// assignSuites drops references to files gone from the pool
+// and adds new files not referenced by any suite to the default suite.
+// Such a file goes to the component from its pool path if it has one.
+// Orphans stay unreferenced until garbage collected.
+func (l *Limbo) assignSuites(s map[string]dist, orphans fileSet) {
+	refd := fileSet{}
+
+	for fn := range orphans {
+		if _, ok := l.pkgs[fn]; !ok {
+			delete(orphans, fn)
+			continue
+		}
+
+		refd[fn] = struct{}{}
+	}
+
+	for _, d := range s {
+		for _, set := range d {
+			for fn := range set {
+				if _, ok := l.pkgs[fn]; !ok {
+					delete(set, fn)
+					continue
+				}
+
+				refd[fn] = struct{}{}
+			}
+		}
+	}
+
+	l.suites = s
+	l.orphans = orphans
+
+	l.dist(l.Suite).component(l.Component)
+
+	for fn := range l.pkgs {
+		if _, ok := refd[fn]; ok {
+			continue
+		}
+
+		l.dist(l.Suite).component(poolComponent(fn, l.Component))[fn] = struct{}{}
+	}
+}
+
+// Promote moves package name of version from suite from to suite to.
+// Packages of all architectures and components matching are moved.
+// Only indexes of the affected components and architectures are regenerated.
+func (l *Limbo) Promote(name, version, from, to string) (moved []*Package, err error) {
+	for _, s := range []string{from, to} {
+		if !validName(s, nameChars) {
+			return nil, &PackageError{Reason: ReasonMalformed, Err: errors.Errorf("bad suite: %q", s)}
+		}
+	}
+
+	if from == to {
+		return nil, &PackageError{Reason: ReasonMalformed, Err: errors.New("promote to the same suite")}
+	}
+
+	err = l.lock()
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		l.unlock(err)
+	}()
+
+	src, ok := l.suites[from]
+	if !ok {
+		return nil, &PackageError{Reason: ReasonNotFound, Err: errors.Errorf("no suite %v", from)}
+	}
+
+	found := map[string][]*Package{} // component -> packages
+
+	for _, cn := range src.components() {
+		for _, fn := range src[cn].list() {
+			p := l.pkgs[fn]
+
+			if p == nil || p.Control.Package != name || p.Control.Version != version {
+				continue
+			}
+
+			found[cn] = append(found[cn], p)
+		}
+	}
+
+	if len(found) == 0 {
+		return nil, &PackageError{Reason: ReasonNotFound, Err: errors.Errorf("%v %v not found in %v", name, version, from)}
+	}
+
+	// destination is created only if something is moved to it
+	dst := l.dist(to)
+
+	comps := map[string]struct{}{}
+	archs := map[string]struct{}{}
+
+	for cn, pkgs := range found {
+		for _, p := range pkgs {
+			delete(src[cn], p.Filename)
+			dst.component(cn)[p.Filename] = struct{}{}
+
+			archs[p.Control.Architecture] = struct{}{}
+
+			moved = append(moved, p)
+		}
+
+		comps[cn] = struct{}{}
+	}
+
+	sortPackages(moved)
+
+	l.tr.Printw("promote", "package", name, "version", version, "from", from, "to", to, "files", len(moved))
+
+	err = l.saveSuites()
+	if err != nil {
+		return nil, errors.Wrap(err, "save suites")
+	}
+
+	for _, s := range []string{from, to} {
+		var al []string
+
+		for a := range archs {
+			al = append(al, l.affectedArchs(s, a)...)
+		}
+
+		err = l.publish(s, setList(comps), uniq(al))
+		if err != nil {
+			return nil, errors.Wrapf(err, "publish %v", s)
+		}
+	}
+
+	return moved, nil
+}
+
+// orphan marks file as orphan if no suite references it. l.mu must be held.
+func (l *Limbo) orphan(fn string) {
+	for _, d := range l.suites {
+		for _, set := range d {
+			if _, ok := set[fn]; ok {
+				return
+			}
+		}
+	}
+
+	if l.orphans == nil {
+		l.orphans = fileSet{}
+	}
+
+	l.orphans[fn] = struct{}{}
+}
+
+// dist returns suite creating it if needed.
+func (l *Limbo) dist(suite string) dist {
+	if l.suites == nil {
+		l.suites = map[string]dist{}
+	}
+
+	d, ok := l.suites[suite]
+	if !ok {
+		d = dist{}
+		l.suites[suite] = d
+	}
+
+	return d
+}
+
+// suiteNames returns sorted suite names.
+func (l *Limbo) suiteNames() (names []string) {
+	for s := range l.suites {
+		names = append(names, s)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// suitePackages returns packages referenced by suite component.
+// All components are taken if component is empty.
+func (l *Limbo) suitePackages(suite, component string) []*Package {
+	return l.distPackages(l.suites[suite], component)
+}
+
+func (l *Limbo) distPackages(d dist, component string) (pkgs []*Package) {
+	for cn, set := range d {
+		if component != "" && cn != component {
+			continue
+		}
+
+		for fn := range set {
+			if p := l.pkgs[fn]; p != nil {
+				pkgs = append(pkgs, p)
+			}
+		}
+	}
+
+	return pkgs
+}
+
+// component returns component files set creating it if needed.
+func (d dist) component(c string) fileSet {
+	set, ok := d[c]
+	if !ok {
+		set = fileSet{}
+		d[c] = set
+	}
+
+	return set
+}
+
+func (d dist) copy() dist {
+	r := dist{}
+
+	for c, set := range d {
+		cp := fileSet{}
+
+		for fn := range set {
+			cp[fn] = struct{}{}
+		}
+
+		r[c] = cp
+	}
+
+	return r
+}
+
+func (d dist) components() (l []string) {
+	for c := range d {
+		l = append(l, c)
+	}
+
+	sort.Strings(l)
+
+	return l
+}
+
+func (s fileSet) list() (l []string) {
+	for fn := range s {
+		l = append(l, fn)
+	}
+
+	sort.Strings(l)
+
+	return l
+}
+
+// poolComponent returns component from pool/<component>/<prefix>/<source>/<file> path or def.
+func poolComponent(fn, def string) string {
+	p := strings.Split(fn, "/")
+
+	if len(p) == 5 && p[0] == "pool" && validName(p[1], nameChars) {
+		return p[1]
+	}
+
+	return def
+}
+
+func setList(s map[string]struct{}) (l []string) {
+	for k := range s {
+		l = append(l, k)
+	}
+
+	sort.Strings(l)
+
+	return l
+}
+
+func uniq(l []string) []string {
+	set := map[string]struct{}{}
+
+	for _, v := range l {
+		set[v] = struct{}{}
+	}
+
+	return setList(set)
+}
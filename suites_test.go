@@ -0,0 +1,78 @@
+package limbo
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPromote(t *testing.T) {
+	l := newTestLimbo(t)
+
+	p, err := l.UploadTo(bytes.NewReader(packageBytes(t, "app", "1.0", "amd64")), "staging", "contrib")
+	require.NoError(t, err)
+
+	assert.Equal(t, "pool/contrib/a/app/app_1.0_amd64.deb", p.Filename)
+
+	index := func(suite string) string {
+		data, err := ioutil.ReadFile(filepath.Join(l.Dists, suite, "contrib", "binary-amd64", "Packages"))
+		require.NoError(t, err)
+
+		return string(data)
+	}
+
+	assert.Contains(t, index("staging"), "Package: app\n")
+
+	rel, err := ioutil.ReadFile(filepath.Join(l.Dists, "staging", "Release"))
+	require.NoError(t, err)
+	assert.Contains(t, string(rel), "Suite: staging\n")
+	assert.Contains(t, string(rel), "Components: contrib\n")
+
+	moved, err := l.Promote("app", "1.0", "staging", "stable")
+	require.NoError(t, err)
+	require.Len(t, moved, 1)
+
+	assert.NotContains(t, index("staging"), "Package: app\n")
+	assert.Contains(t, index("stable"), "Filename: pool/contrib/a/app/app_1.0_amd64.deb\n")
+
+	rel, err = ioutil.ReadFile(filepath.Join(l.Dists, "stable", "Release"))
+	require.NoError(t, err)
+	assert.Contains(t, string(rel), "Components: contrib main\n")
+
+	var pe *PackageError
+
+	_, err = l.Promote("app", "1.0", "staging", "stable")
+	if assert.True(t, errors.As(err, &pe), "%v", err) {
+		assert.Equal(t, ReasonNotFound, pe.Reason)
+	}
+
+	// nothing moved, no destination suite created
+	_, err = l.Promote("app", "2.0", "stable", "testing")
+	if assert.True(t, errors.As(err, &pe), "%v", err) {
+		assert.Equal(t, ReasonNotFound, pe.Reason)
+	}
+
+	_, ok := l.suites["testing"]
+	assert.False(t, ok, "testing suite created")
+
+	_, err = l.Promote("app", "1.0", "../stable", "dev")
+	if assert.True(t, errors.As(err, &pe), "%v", err) {
+		assert.Equal(t, ReasonMalformed, pe.Reason)
+	}
+
+	// references survive restart
+	l, err = New(context.Background(), l.Path)
+	require.NoError(t, err)
+
+	err = l.UpdateIndex()
+	require.NoError(t, err)
+
+	assert.NotContains(t, index("staging"), "Package: app\n")
+	assert.Contains(t, index("stable"), "Package: app\n")
+}
@@ -57,6 +57,11 @@ func (w *Writer) ValueString(v string) (err error) {
 		return errors.New("value is not expected")
 	}
 
+	if len(v) != 0 && v[0] == '\n' {
+		// multiline value starting from the next line
+		w.b = w.b[:len(w.b)-1]
+	}
+
 	st := 0
 
 	addv := func(i int) {
@@ -89,7 +94,7 @@ func (w *Writer) ValueString(v string) (err error) {
 	w.b = w.b[:0]
 	w.state = 0
 
-	return nil
+	return err
 }
 
 func (w *Writer) Key(k []byte) error {
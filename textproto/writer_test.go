@@ -20,9 +20,15 @@ multiple
 	lines`)
 	assert.NoError(t, err)
 
+	err = w.PairStrings("list", "\nfirst\nsecond")
+	assert.NoError(t, err)
+
 	assert.Equal(t, `Key: value
 Complex-Key: long value
  multiple
  	lines
+List:
+ first
+ second
 `, buf.String())
 }
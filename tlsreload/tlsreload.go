@@ -0,0 +1,123 @@
+package tlsreload
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	// Loader keeps TLS certificate loaded from files
+	// and reloads it when they change.
+	// The previous certificate is kept if the new one fails to load.
+	Loader struct {
+		CertFile string
+		KeyFile  string
+
+		mu    sync.RWMutex
+		cert  *tls.Certificate
+		mtime [2]time.Time
+	}
+)
+
+// New loads certificate and key files.
+func New(certFile, keyFile string) (*Loader, error) {
+	l := &Loader{
+		CertFile: certFile,
+		KeyFile:  keyFile,
+	}
+
+	err := l.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Reload loads the certificate from files.
+func (l *Loader) Reload() error {
+	mt, err := l.mtimes()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(l.CertFile, l.KeyFile)
+	if err != nil {
+		return errors.Wrap(err, "load key pair")
+	}
+
+	l.mu.Lock()
+	l.cert = &cert
+	l.mtime = mt
+	l.mu.Unlock()
+
+	return nil
+}
+
+// Changed reports whether files were modified since the last load.
+func (l *Loader) Changed() (bool, error) {
+	mt, err := l.mtimes()
+	if err != nil {
+		return false, err
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return mt != l.mtime, nil
+}
+
+// Watch checks files every interval and reloads them if changed until ctx is done.
+// Errors are passed to onErr if it's not nil.
+// Non-positive interval disables polling, Watch returns immediately.
+func (l *Loader) Watch(ctx context.Context, interval time.Duration, onErr func(error)) {
+	if interval <= 0 {
+		return
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		ch, err := l.Changed()
+		if err == nil && ch {
+			err = l.Reload()
+		}
+
+		if err != nil && onErr != nil {
+			onErr(err)
+		}
+	}
+}
+
+// GetCertificate is tls.Config.GetCertificate.
+func (l *Loader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.cert, nil
+}
+
+func (l *Loader) mtimes() (mt [2]time.Time, err error) {
+	for i, fn := range []string{l.CertFile, l.KeyFile} {
+		inf, err := os.Stat(fn)
+		if err != nil {
+			return mt, errors.Wrap(err, "stat")
+		}
+
+		mt[i] = inf.ModTime()
+	}
+
+	return mt, nil
+}
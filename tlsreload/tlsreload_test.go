@@ -0,0 +1,121 @@
+package tlsreload
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsreload")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cf := filepath.Join(dir, "cert.pem")
+	kf := filepath.Join(dir, "key.pem")
+
+	writeCert(t, cf, kf, "one", time.Now().Add(-time.Hour))
+
+	l, err := New(cf, kf)
+	require.NoError(t, err)
+
+	assert.Equal(t, "one", commonName(t, l))
+
+	ch, err := l.Changed()
+	require.NoError(t, err)
+	assert.False(t, ch)
+
+	writeCert(t, cf, kf, "two", time.Now())
+
+	ch, err = l.Changed()
+	require.NoError(t, err)
+	assert.True(t, ch)
+
+	err = l.Reload()
+	require.NoError(t, err)
+
+	assert.Equal(t, "two", commonName(t, l))
+
+	// broken files keep the previous certificate
+	err = ioutil.WriteFile(kf, []byte("garbage"), 0600)
+	require.NoError(t, err)
+
+	err = l.Reload()
+	assert.Error(t, err)
+
+	assert.Equal(t, "two", commonName(t, l))
+}
+
+func commonName(t *testing.T, l *Loader) string {
+	t.Helper()
+
+	c, err := l.GetCertificate(nil)
+	require.NoError(t, err)
+
+	x, err := x509.ParseCertificate(c.Certificate[0])
+	require.NoError(t, err)
+
+	return x.Subject.CommonName
+}
+
+func writeCert(t *testing.T, cf, kf, cn string, mtime time.Time) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	kder, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	err = ioutil.WriteFile(cf, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	require.NoError(t, err)
+
+	err = ioutil.WriteFile(kf, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder}), 0600)
+	require.NoError(t, err)
+
+	for _, f := range []string{cf, kf} {
+		err = os.Chtimes(f, mtime, mtime)
+		require.NoError(t, err)
+	}
+}
+
+func TestWatchNoInterval(t *testing.T) {
+	l := &Loader{}
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		l.Watch(context.Background(), 0, nil)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Watch with zero interval doesn't return")
+	}
+}
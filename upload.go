@@ -0,0 +1,279 @@
+package limbo
+
+import (
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/rndcenter/limbo/deb"
+)
+
+type (
+	// PackageError is returned when a package is rejected.
+	PackageError struct {
+		Reason string
+		Err    error
+	}
+
+	// recordWriter keeps the first write error
+	// to tell it from the errors of the reader it's teed from.
+	recordWriter struct {
+		w   io.Writer
+		err error
+	}
+)
+
+const (
+	nameChars    = "abcdefghijklmnopqrstuvwxyz0123456789+-."
+	versionChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789.+~:-"
+	archChars    = "abcdefghijklmnopqrstuvwxyz0123456789-"
+)
+
+// PackageError reasons.
+const (
+	ReasonMalformed = "malformed"
+	ReasonConflict  = "conflict"
+	ReasonNotFound  = "not_found"
+)
+
+// Upload reads a deb package from r and adds it to the default suite and component.
+func (l *Limbo) Upload(r io.Reader) (*Package, error) {
+	return l.UploadTo(r, "", "")
+}
+
+// UploadTo reads a deb package from r, stores it in the pool
+// under its canonical path, adds it to suite component and updates the affected indexes.
+// Empty suite or component means the default one.
+func (l *Limbo) UploadTo(r io.Reader, suite, component string) (p *Package, err error) {
+	defer func() {
+		l.metrics.upload(err)
+	}()
+
+	if suite == "" {
+		suite = l.Suite
+	}
+
+	if component == "" {
+		component = l.Component
+	}
+
+	for _, f := range []struct{ name, val string }{
+		{"suite", suite},
+		{"component", component},
+	} {
+		if !validName(f.val, nameChars) {
+			return nil, &PackageError{Reason: ReasonMalformed, Err: errors.Errorf("bad %v: %q", f.name, f.val)}
+		}
+	}
+
+	err = os.MkdirAll(l.Pool, 0755)
+	if err != nil {
+		return nil, errors.Wrap(err, "create pool dir")
+	}
+
+	tmp, err := ioutil.TempFile(l.Pool, ".upload-*.tmp")
+	if err != nil {
+		return nil, errors.Wrap(err, "create temp file")
+	}
+	defer func() {
+		_ = tmp.Close()
+
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	d := deb.New(l.ctx)
+	d.MetaOnly = true
+
+	w := &recordWriter{w: tmp}
+
+	n, err := d.ReadFrom(io.TeeReader(r, w))
+	if w.err != nil {
+		// it's our disk failed, not the package
+		return nil, errors.Wrap(w.err, "write temp file")
+	}
+	if err != nil {
+		return nil, &PackageError{Reason: ReasonMalformed, Err: err}
+	}
+
+	err = checkControl(&d.Control)
+	if err != nil {
+		return nil, &PackageError{Reason: ReasonMalformed, Err: err}
+	}
+
+	err = tmp.Sync()
+	if err != nil {
+		return nil, errors.Wrap(err, "sync temp file")
+	}
+
+	p = &Package{
+		Filename:  poolPath(component, d),
+		Size:      n,
+		MD5Sum:    hex.EncodeToString(d.MD5Sum[:]),
+		SHA1Sum:   hex.EncodeToString(d.SHA1Sum[:]),
+		SHA256Sum: hex.EncodeToString(d.SHA256Sum[:]),
+		Control:   d.Control,
+	}
+
+	err = l.lock()
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		l.unlock(err)
+	}()
+
+	if old, ok := l.pkgs[p.Filename]; ok {
+		if old.SHA256Sum != p.SHA256Sum {
+			return nil, &PackageError{Reason: ReasonConflict, Err: errors.Errorf("%v exists with different content", p.Filename)}
+		}
+
+		_ = os.Remove(tmp.Name())
+
+		files := l.dist(suite).component(component)
+		if _, ok := files[old.Filename]; ok {
+			return old, nil
+		}
+
+		files[old.Filename] = struct{}{}
+		delete(l.orphans, old.Filename)
+
+		err = l.saveSuites()
+		if err != nil {
+			return nil, errors.Wrap(err, "save suites")
+		}
+
+		err = l.publish(suite, []string{component}, l.affectedArchs(suite, old.Control.Architecture))
+		if err != nil {
+			return nil, errors.Wrap(err, "update index")
+		}
+
+		return old, nil
+	}
+
+	dst := filepath.Join(l.Path, filepath.FromSlash(p.Filename))
+
+	err = os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		return nil, errors.Wrap(err, "create dir")
+	}
+
+	err = os.Rename(tmp.Name(), dst)
+	if err != nil {
+		return nil, errors.Wrap(err, "move to pool")
+	}
+
+	l.tr.Printw("package uploaded", "file", p.Filename, "size", p.Size, "suite", suite)
+
+	if l.pkgs == nil {
+		l.pkgs = make(map[string]*Package)
+	}
+
+	l.pkgs[p.Filename] = p
+
+	inf, err := os.Stat(dst)
+	if err != nil {
+		return nil, errors.Wrap(err, "stat pool file")
+	}
+
+	if l.cache == nil {
+		l.cache = poolCache{}
+	}
+
+	l.cache.add(p, inf)
+
+	err = l.saveCache(l.cache)
+	if err != nil {
+		return nil, errors.Wrap(err, "save pool cache")
+	}
+
+	l.dist(suite).component(component)[p.Filename] = struct{}{}
+
+	err = l.saveSuites()
+	if err != nil {
+		return nil, errors.Wrap(err, "save suites")
+	}
+
+	err = l.publish(suite, []string{component}, l.affectedArchs(suite, p.Control.Architecture))
+	if err != nil {
+		return nil, errors.Wrap(err, "update index")
+	}
+
+	return p, nil
+}
+
+// poolPath returns repo relative path to store package at.
+// It follows Debian layout: pool/<component>/<prefix>/<source>/<name>_<version>_<arch>.deb.
+// Version epoch is not a part of the file name as in Debian archive.
+func poolPath(component string, p *deb.Package) string {
+	src := p.Control.Package
+
+	if s, ok := p.Control.Rest["Source"].(string); ok {
+		// Source may be followed by version: "src (1.0)"
+		if f := strings.Fields(s); len(f) != 0 && validName(f[0], nameChars) {
+			src = f[0]
+		}
+	}
+
+	prefix := src[:1]
+	if strings.HasPrefix(src, "lib") && len(src) > 3 {
+		prefix = src[:4]
+	}
+
+	ver := p.Control.Version
+	if i := strings.IndexByte(ver, ':'); i >= 0 {
+		ver = ver[i+1:]
+	}
+
+	return path.Join("pool", component, prefix, src, p.Control.Package+"_"+ver+"_"+p.Control.Architecture+".deb")
+}
+
+// checkControl validates fields used to build pool path.
+func checkControl(c *deb.Control) error {
+	for _, f := range []struct {
+		name, val, chars string
+	}{
+		{"package", c.Package, nameChars},
+		{"version", c.Version, versionChars},
+		{"architecture", c.Architecture, archChars},
+	} {
+		if f.val == "" {
+			return errors.Errorf("%v is required", f.name)
+		}
+
+		if !validName(f.val, f.chars) {
+			return errors.Errorf("bad %v: %q", f.name, f.val)
+		}
+	}
+
+	return nil
+}
+
+func validName(s, chars string) bool {
+	return s != "" && strings.Trim(s, chars) == "" && s[0] != '.' && s[0] != '-'
+}
+
+func (w *recordWriter) Write(p []byte) (n int, err error) {
+	n, err = w.w.Write(p)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+
+	return n, err
+}
+
+func (e *PackageError) Error() string {
+	return e.Reason + ": " + e.Err.Error()
+}
+
+func (e *PackageError) Unwrap() error {
+	return e.Err
+}
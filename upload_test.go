@@ -0,0 +1,173 @@
+package limbo
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rndcenter/limbo/deb"
+)
+
+func TestUpload(t *testing.T) {
+	l := newTestLimbo(t)
+
+	data := packageBytes(t, "libfoo", "1.0", "amd64")
+
+	p, err := l.Upload(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	assert.Equal(t, "pool/main/libf/libfoo/libfoo_1.0_amd64.deb", p.Filename)
+	assert.Equal(t, int64(len(data)), p.Size)
+
+	_, err = os.Stat(filepath.Join(l.Path, p.Filename))
+	assert.NoError(t, err)
+
+	idx, err := ioutil.ReadFile(filepath.Join(l.Dists, "stable", "main", "binary-amd64", "Packages"))
+	require.NoError(t, err)
+	assert.Contains(t, string(idx), "Filename: pool/main/libf/libfoo/libfoo_1.0_amd64.deb\n")
+
+	// same content is accepted
+	_, err = l.Upload(bytes.NewReader(data))
+	assert.NoError(t, err)
+
+	var pe *PackageError
+
+	_, err = l.Upload(strings.NewReader("not a deb"))
+	if assert.True(t, errors.As(err, &pe), "%v", err) {
+		assert.Equal(t, ReasonMalformed, pe.Reason)
+	}
+
+	_, err = l.Upload(bytes.NewReader(packageBytes(t, "../evil", "1.0", "amd64")))
+	if assert.True(t, errors.As(err, &pe), "%v", err) {
+		assert.Equal(t, ReasonMalformed, pe.Reason)
+	}
+
+	// epoch is not in the file name
+	p, err = l.Upload(bytes.NewReader(packageBytes(t, "app", "1:2.0-1", "amd64")))
+	require.NoError(t, err)
+	assert.Equal(t, "pool/main/a/app/app_2.0-1_amd64.deb", p.Filename)
+	assert.Equal(t, "1:2.0-1", p.Control.Version)
+
+	idx, err = ioutil.ReadFile(filepath.Join(l.Dists, "stable", "main", "binary-amd64", "Packages"))
+	require.NoError(t, err)
+	assert.Contains(t, string(idx), "Version: 1:2.0-1\n")
+	assert.Contains(t, string(idx), "Filename: pool/main/a/app/app_2.0-1_amd64.deb\n")
+
+	_, err = l.Upload(bytes.NewReader(packageBytes(t, "app", "2:2.0-1", "amd64")))
+	if assert.True(t, errors.As(err, &pe), "%v", err) {
+		assert.Equal(t, ReasonConflict, pe.Reason)
+	}
+
+	tmp, err := filepath.Glob(filepath.Join(l.Pool, ".upload-*"))
+	require.NoError(t, err)
+	assert.Empty(t, tmp)
+}
+
+func TestClose(t *testing.T) {
+	l := newTestLimbo(t)
+
+	_, err := l.UploadTo(bytes.NewReader(packageBytes(t, "app", "1.0", "amd64")), "staging", "")
+	require.NoError(t, err)
+
+	_, err = l.CreateSnapshot("staging", "before")
+	require.NoError(t, err)
+
+	suites, err := ioutil.ReadFile(l.suitesFile())
+	require.NoError(t, err)
+
+	err = l.Close()
+	require.NoError(t, err)
+
+	_, err = l.Upload(bytes.NewReader(packageBytes(t, "libfoo", "1.0", "amd64")))
+	assert.True(t, errors.Is(err, ErrClosed), "upload: %v", err)
+
+	_, err = l.Promote("app", "1.0", "staging", "stable")
+	assert.True(t, errors.Is(err, ErrClosed), "promote: %v", err)
+
+	_, err = l.Remove("app", "1.0", "amd64", "staging", true)
+	assert.True(t, errors.Is(err, ErrClosed), "remove: %v", err)
+
+	_, err = l.GC(false)
+	assert.True(t, errors.Is(err, ErrClosed), "gc: %v", err)
+
+	_, err = l.CreateSnapshot("staging", "after")
+	assert.True(t, errors.Is(err, ErrClosed), "snapshot: %v", err)
+
+	err = l.Load()
+	assert.True(t, errors.Is(err, ErrClosed), "load: %v", err)
+
+	// nothing is changed
+	after, err := ioutil.ReadFile(l.suitesFile())
+	require.NoError(t, err)
+	assert.Equal(t, string(suites), string(after))
+
+	_, err = os.Stat(filepath.Join(l.Path, "pool/main/a/app/app_1.0_amd64.deb"))
+	assert.NoError(t, err)
+
+	fis, err := ioutil.ReadDir(l.Pool)
+	require.NoError(t, err)
+
+	for _, fi := range fis {
+		assert.False(t, strings.HasPrefix(fi.Name(), ".upload-"), "temp file left: %v", fi.Name())
+	}
+}
+
+func packageBytes(t testing.TB, name, ver, arch string) []byte {
+	t.Helper()
+
+	p := deb.New(context.Background())
+
+	p.Control = deb.Control{
+		Package:      name,
+		Version:      ver,
+		Architecture: arch,
+		Description:  "test package",
+	}
+
+	// the same package must have the same content even if built in different seconds
+	p.Reproducible = true
+	p.SourceDate = time.Unix(1600000000, 0)
+
+	var b bytes.Buffer
+
+	_, err := p.WriteTo(&b)
+	require.NoError(t, err)
+
+	return b.Bytes()
+}
+
+func TestRecordWriter(t *testing.T) {
+	w := &recordWriter{w: failWriter{}}
+
+	_, err := ioutil.ReadAll(io.TeeReader(strings.NewReader("data"), w))
+	assert.Error(t, err)
+	assert.EqualError(t, w.err, "disk full")
+}
+
+type failWriter struct{}
+
+func (failWriter) Write(p []byte) (int, error) { return 0, errors.New("disk full") }
+
+func newTestLimbo(t testing.TB) *Limbo {
+	root, err := ioutil.TempDir("", "limbo_test")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(root) })
+
+	l, err := New(context.Background(), root)
+	require.NoError(t, err)
+
+	err = l.UpdateIndex()
+	require.NoError(t, err)
+
+	return l
+}